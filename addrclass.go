@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net"
+)
+
+// Address types reported in IPInfo.AddressType
+const (
+	addressPublic        = "public"
+	addressPrivate       = "private"
+	addressLoopback      = "loopback"
+	addressCGNAT         = "cgnat"
+	addressReserved      = "reserved"
+	addressMulticast     = "multicast"
+	addressDocumentation = "documentation"
+	addressBogon         = "bogon"
+)
+
+// addressRange maps a special-purpose prefix to its address type
+type addressRange struct {
+	network     *net.IPNet
+	addressType string
+}
+
+// Special-purpose address ranges (RFC 6890 and the IANA special-purpose
+// registries). The first matching range wins.
+var specialRanges = mustParseRanges([][2]string{
+	// IPv4
+	{"0.0.0.0/8", addressBogon},               // "This network"
+	{"10.0.0.0/8", addressPrivate},            // RFC 1918
+	{"100.64.0.0/10", addressCGNAT},           // RFC 6598 shared address space
+	{"127.0.0.0/8", addressLoopback},          // RFC 1122
+	{"169.254.0.0/16", addressReserved},       // Link-local
+	{"172.16.0.0/12", addressPrivate},         // RFC 1918
+	{"192.0.0.0/24", addressReserved},         // IETF protocol assignments
+	{"192.0.2.0/24", addressDocumentation},    // TEST-NET-1
+	{"192.88.99.0/24", addressReserved},       // Deprecated 6to4 relay anycast
+	{"192.168.0.0/16", addressPrivate},        // RFC 1918
+	{"198.18.0.0/15", addressReserved},        // Benchmarking
+	{"198.51.100.0/24", addressDocumentation}, // TEST-NET-2
+	{"203.0.113.0/24", addressDocumentation},  // TEST-NET-3
+	{"224.0.0.0/4", addressMulticast},
+	{"240.0.0.0/4", addressReserved}, // Reserved for future use, includes broadcast
+
+	// IPv6
+	{"::/128", addressBogon}, // Unspecified
+	{"::1/128", addressLoopback},
+	{"64:ff9b:1::/48", addressReserved}, // Local-use NAT64
+	{"100::/64", addressReserved},       // Discard-only
+	{"2001:db8::/32", addressDocumentation},
+	{"3fff::/20", addressDocumentation}, // RFC 9637
+	{"fc00::/7", addressPrivate},        // Unique local
+	{"fe80::/10", addressReserved},      // Link-local
+	{"ff00::/8", addressMulticast},
+})
+
+// Global unicast IPv6 space; anything outside it is a bogon
+var globalUnicastIPv6 = mustParseRanges([][2]string{{"2000::/3", addressPublic}})[0].network
+
+func mustParseRanges(ranges [][2]string) []addressRange {
+	parsed := make([]addressRange, len(ranges))
+	for i, r := range ranges {
+		_, network, err := net.ParseCIDR(r[0])
+		if err != nil {
+			panic(err)
+		}
+		parsed[i] = addressRange{network: network, addressType: r[1]}
+	}
+	return parsed
+}
+
+// classifyIP returns the address type of ip, "public" for addresses that
+// can be looked up in the databases
+func classifyIP(ip net.IP) string {
+	// Treat IPv4-mapped IPv6 addresses as IPv4
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	for _, r := range specialRanges {
+		if r.network.Contains(ip) {
+			return r.addressType
+		}
+	}
+
+	if len(ip) == net.IPv6len && !globalUnicastIPv6.Contains(ip) {
+		return addressBogon
+	}
+	return addressPublic
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+)
+
+func TestClassifyIP(t *testing.T) {
+	tests := map[string]string{
+		"8.8.8.8":            addressPublic,
+		"2a00:1450:4001::1":  addressPublic,
+		"10.0.0.1":           addressPrivate,
+		"172.31.255.255":     addressPrivate,
+		"192.168.1.1":        addressPrivate,
+		"fd12:3456::1":       addressPrivate,
+		"127.0.0.1":          addressLoopback,
+		"::1":                addressLoopback,
+		"100.64.0.1":         addressCGNAT,
+		"100.127.255.254":    addressCGNAT,
+		"100.128.0.1":        addressPublic,
+		"169.254.169.254":    addressReserved,
+		"fe80::1":            addressReserved,
+		"198.18.0.1":         addressReserved,
+		"255.255.255.255":    addressReserved,
+		"224.0.0.251":        addressMulticast,
+		"ff02::fb":           addressMulticast,
+		"192.0.2.10":         addressDocumentation,
+		"198.51.100.7":       addressDocumentation,
+		"203.0.113.99":       addressDocumentation,
+		"2001:db8::1":        addressDocumentation,
+		"0.1.2.3":            addressBogon,
+		"::":                 addressBogon,
+		"4000::1":            addressBogon,
+		"::ffff:10.1.2.3":    addressPrivate,
+		"::ffff:8.8.4.4":     addressPublic,
+		"2001:4860:4860::88": addressPublic,
+	}
+
+	for input, expected := range tests {
+		ip := net.ParseIP(input)
+		if ip == nil {
+			t.Fatalf("Failed to parse test IP %s", input)
+		}
+		if got := classifyIP(ip); got != expected {
+			t.Errorf("classifyIP(%s) = %s, expected %s", input, got, expected)
+		}
+	}
+}
+
+func TestGetIPInfoNonPublic(t *testing.T) {
+	originalDatabases := databases
+	defer func() { databases = originalDatabases }()
+
+	// Readers that fail prove that the databases aren't consulted
+	errorReader := &ErrorMockReader{}
+	databases = map[string]*dbConfig{
+		"asn":     {reader: errorReader},
+		"city":    {reader: errorReader},
+		"country": {reader: errorReader},
+	}
+
+	info, err := getIPInfo(net.ParseIP("10.0.0.1"))
+	if err != nil {
+		t.Fatalf("getIPInfo failed for private address: %v", err)
+	}
+	if info.AddressType != addressPrivate || info.IsPublic {
+		t.Errorf("Expected private, non-public address, got %s (public %v)", info.AddressType, info.IsPublic)
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatalf("Failed to marshal IP info: %v", err)
+	}
+	expected := `{"ip":"10.0.0.1","network":"10.0.0.0/24","version":"IPv4","address_type":"private","is_public":false}`
+	if string(data) != expected {
+		t.Errorf("Expected %s, got %s", expected, data)
+	}
+
+	// Public addresses are still looked up
+	databases = map[string]*dbConfig{
+		"asn":     {reader: &MockReader{}},
+		"city":    {reader: &MockReader{}},
+		"country": {reader: &MockReader{}},
+	}
+	info, err = getIPInfo(net.ParseIP("8.8.8.8"))
+	if err != nil {
+		t.Fatalf("getIPInfo failed for public address: %v", err)
+	}
+	if info.AddressType != addressPublic || !info.IsPublic || info.City != "Test City" {
+		t.Errorf("Expected public address with database data, got %+v", info)
+	}
+}
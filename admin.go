@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// authorizeAdmin checks the bearer token of an /admin/ request. The admin
+// API is disabled when no token is configured.
+func authorizeAdmin(r *http.Request) bool {
+	if config.AdminToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) == 1
+}
+
+// handleAdmin serves the /admin/ API:
+//
+//	GET  /admin/overrides       the loaded overrides, longest prefix first
+//	GET  /admin/overrides/{ip}  the override matching ip and the merged result
+//	POST /admin/reload          reload the overrides, policies, cloud range and list files
+func handleAdmin(w http.ResponseWriter, r *http.Request) {
+	reqLogger := requestLogger(r)
+
+	if !authorizeAdmin(r) {
+		reqLogger.Debug("Rejecting unauthorized admin request", "path", r.URL.Path)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/admin/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "overrides" && r.Method == http.MethodGet:
+		writeJSON(w, r, listOverrides())
+	case len(parts) == 2 && parts[0] == "overrides" && r.Method == http.MethodGet:
+		handleTestOverride(w, r, parts[1])
+	case len(parts) == 1 && parts[0] == "reload" && r.Method == http.MethodPost:
+		if err := reloadAll(); err != nil {
+			reqLogger.Error("Reload failed", "error", err)
+			http.Error(w, "Reload failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, map[string]int{"overrides": len(listOverrides()), "policies": len(policyNames())})
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// handleTestOverride shows which override applies to an address and the
+// response it produces
+func handleTestOverride(w http.ResponseWriter, r *http.Request, ipAddress string) {
+	ip := net.ParseIP(ipAddress)
+	if ip == nil {
+		http.Error(w, "Invalid IP address", http.StatusBadRequest)
+		return
+	}
+
+	info, err := getIPInfo(ip)
+	if err != nil {
+		requestLogger(r).Error("Error getting IP info", "ip", maskIP(ipAddress), "error", err)
+		http.Error(w, "Error getting IP info: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, r, struct {
+		Override *Override `json:"override"`
+		Result   *IPInfo   `json:"result"`
+	}{matchOverride(ip), info})
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	writeJSONStatus(w, r, http.StatusOK, v)
+}
+
+func writeJSONStatus(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		requestLogger(r).Error("Error encoding JSON response", "error", err)
+	}
+}
+
+// reloadAll reloads the data files that can change without a restart
+func reloadAll() error {
+	if err := reloadOverrides(); err != nil {
+		return err
+	}
+	if err := reloadPolicies(); err != nil {
+		return err
+	}
+	if err := reloadCloudRanges(); err != nil {
+		return err
+	}
+	return reloadLists()
+}
+
+// watchReloadSignal reloads on SIGHUP, keeping the previous data when a
+// file fails to load
+func watchReloadSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		logger.Info("Received SIGHUP, reloading")
+		if err := reloadAll(); err != nil {
+			logger.Error("Reload failed", "error", err)
+		}
+	}
+}
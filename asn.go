@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// asnIndex maps autonomous systems to the prefixes they announce, built by
+// walking the whole ASN database
+type asnIndex struct {
+	orgs      map[uint]string
+	prefixes  map[uint][]netip.Prefix
+	countries map[uint]map[string]int // Location database networks inside the prefixes, by country; nil without one
+	networks  int
+}
+
+var (
+	asnIndexMutex sync.RWMutex
+	asnIdx        *asnIndex // nil until the ASN database has been walked
+)
+
+// buildASNIndex walks the ASN database, then the location database inside
+// its prefixes, and replaces the index. The index is cleared if the ASN
+// database isn't loaded or can't be walked.
+func buildASNIndex() {
+	start := time.Now()
+	index := &asnIndex{orgs: map[uint]string{}, prefixes: map[uint][]netip.Prefix{}}
+	walked := false
+	_, err := readDatabase("asn", func(reader Reader) error {
+		walker, ok := reader.(networkWalker)
+		if !ok {
+			return nil
+		}
+		walked = true
+
+		it := walker.Networks()
+		for it.Next() {
+			var record geoip2.ASN
+			network, err := it.Network(&record)
+			if err != nil {
+				return err
+			}
+			addr, _ := netip.AddrFromSlice(network.IP)
+			bits, _ := network.Mask.Size()
+			number := record.AutonomousSystemNumber
+			index.orgs[number] = record.AutonomousSystemOrganization
+			index.prefixes[number] = append(index.prefixes[number], netip.PrefixFrom(addr.Unmap(), bits))
+			index.networks++
+		}
+		return it.Err()
+	})
+	if err == nil && walked {
+		err = countASNCountries(index)
+	}
+	if err != nil {
+		// Keep serving the previous index
+		logger.Error("Failed to build ASN index", "error", err)
+		return
+	}
+	if !walked {
+		index = nil
+	}
+
+	asnIndexMutex.Lock()
+	asnIdx = index
+	asnIndexMutex.Unlock()
+
+	if index != nil {
+		logger.Info("Built ASN index", "asns", len(index.orgs), "networks", index.networks, "duration_ms", time.Since(start).Milliseconds())
+	}
+}
+
+// asnIndexReads reports whether the ASN index reads the named database. The
+// country database is only read when there is no city database.
+func asnIndexReads(name string) bool {
+	switch name {
+	case "asn", "city":
+		return true
+	case "country":
+		cityLoaded, _ := readDatabase("city", func(Reader) error { return nil })
+		return !cityLoaded
+	}
+	return false
+}
+
+// countASNCountries counts the city (or country) database networks inside
+// the prefixes of each autonomous system. Networks are counted as the
+// database stores them, so a /16 and a /24 both count once.
+func countASNCountries(index *asnIndex) error {
+	for number, prefixes := range index.prefixes {
+		for _, prefix := range prefixes {
+			entries, ok, err := walkLocation(prefix, -1)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return nil
+			}
+			if index.countries == nil {
+				index.countries = map[uint]map[string]int{}
+			}
+			counts := index.countries[number]
+			if counts == nil {
+				counts = map[string]int{}
+				index.countries[number] = counts
+			}
+			for _, entry := range entries {
+				if entry.data.CountryCode != "" {
+					counts[entry.data.CountryCode]++
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// parseASN parses an AS number with or without the AS prefix
+func parseASN(s string) (uint, error) {
+	if len(s) > 2 && strings.EqualFold(s[:2], "AS") {
+		s = s[2:]
+	}
+	number, err := strconv.ParseUint(s, 10, 32)
+	return uint(number), err
+}
+
+// asnResult is the response of GET /asn/{number}
+type asnResult struct {
+	ASN       string         `json:"asn"`
+	Org       string         `json:"org"`
+	Prefixes  []string       `json:"prefixes"`
+	Countries map[string]int `json:"countries,omitempty"` // Number of location database networks inside the prefixes, by country
+}
+
+// handleASN serves /asn/{number} with the organization and prefixes of an
+// autonomous system
+func handleASN(w http.ResponseWriter, r *http.Request, asn string) {
+	reqLogger := requestLogger(r)
+
+	number, err := parseASN(asn)
+	if err != nil {
+		http.Error(w, "Invalid AS number", http.StatusBadRequest)
+		return
+	}
+
+	asnIndexMutex.RLock()
+	index := asnIdx
+	asnIndexMutex.RUnlock()
+	if index == nil {
+		http.Error(w, "ASN lookups need the ASN database", http.StatusServiceUnavailable)
+		return
+	}
+	org, ok := index.orgs[number]
+	if !ok {
+		http.Error(w, "AS not found", http.StatusNotFound)
+		return
+	}
+
+	// Prefixes are in address order, as the database was walked
+	prefixes := index.prefixes[number]
+	result := asnResult{ASN: fmt.Sprintf("AS%d", number), Org: org, Prefixes: make([]string, len(prefixes))}
+	for i, prefix := range prefixes {
+		result.Prefixes[i] = prefix.String()
+	}
+	result.Countries = index.countries[number]
+
+	reqLogger.Debug("Successfully processed ASN", "asn", number, "prefixes", len(prefixes))
+	writeJSON(w, r, result)
+}
+
+// memory estimates the bytes used by the index
+func (index *asnIndex) memory() int64 {
+	const (
+		mapEntry    = 8 + 16 + 24 // Key, org string header and prefix slice header
+		prefixBytes = 32          // Size of netip.Prefix
+	)
+	const countryEntry = 16 + 8 // Country code and count
+	var size int64
+	for number, org := range index.orgs {
+		size += mapEntry + int64(len(org)) + int64(cap(index.prefixes[number]))*prefixBytes
+		size += int64(len(index.countries[number])) * countryEntry
+	}
+	return size
+}
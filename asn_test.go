@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+func TestHandleASN(t *testing.T) {
+	originalDatabases := databases
+	originalConfig := config
+	defer func() {
+		databases = originalDatabases
+		config = originalConfig
+		buildASNIndex()
+	}()
+
+	config = defaultConfig
+	aaisp := geoip2.ASN{AutonomousSystemNumber: 20712, AutonomousSystemOrganization: "Andrews & Arnold Ltd"}
+	databases = map[string]*dbConfig{
+		"asn": {reader: &walkableReader{networks: []fakeNetwork{
+			{"81.2.64.0/20", aaisp},
+			{"81.2.96.0/24", geoip2.ASN{AutonomousSystemNumber: 64500, AutonomousSystemOrganization: "Example"}},
+			{"2001:8b0::/32", aaisp},
+		}}},
+		"city": {reader: &walkableReader{networks: []fakeNetwork{
+			{"81.2.68.0/23", locationRecord("GB", "United Kingdom", "London")},
+			{"81.2.70.0/24", locationRecord("GB", "United Kingdom", "")},
+			{"81.2.72.0/24", locationRecord("IE", "Ireland", "Dublin")},
+			{"2001:8b0::/32", locationRecord("GB", "United Kingdom", "")},
+		}}},
+	}
+	buildASNIndex()
+
+	for _, asn := range []string{"20712", "AS20712", "as20712"} {
+		w := httptest.NewRecorder()
+		handleRequest(w, httptest.NewRequest("GET", "/asn/"+asn, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200 for %s, got %d %s", asn, w.Code, w.Body.String())
+		}
+		var result asnResult
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		expected := asnResult{
+			ASN:       "AS20712",
+			Org:       "Andrews & Arnold Ltd",
+			Prefixes:  []string{"81.2.64.0/20", "2001:8b0::/32"},
+			Countries: map[string]int{"GB": 3, "IE": 1},
+		}
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("Expected %+v, got %+v", expected, result)
+		}
+	}
+
+	// The counts come from the index and follow location database reloads
+	countries := func() map[string]int {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handleRequest(w, httptest.NewRequest("GET", "/asn/20712", nil))
+		var result asnResult
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return result.Countries
+	}
+	delete(databases, "city")
+	databases["country"] = &dbConfig{reader: &walkableReader{networks: []fakeNetwork{
+		{"81.2.72.0/24", locationRecord("IE", "Ireland", "")},
+	}}}
+	if got := countries(); !reflect.DeepEqual(got, map[string]int{"GB": 3, "IE": 1}) {
+		t.Errorf("Expected counts from the index, got %v", got)
+	}
+	markIndexesStale("country")
+	rebuildStaleIndexes()
+	waitForIndexes()
+	if got := countries(); !reflect.DeepEqual(got, map[string]int{"IE": 1}) {
+		t.Errorf("Expected counts rebuilt from the new country database, got %v", got)
+	}
+
+	for path, status := range map[string]int{
+		"/asn/64501":       http.StatusNotFound,
+		"/asn/ASX":         http.StatusBadRequest,
+		"/asn/99999999999": http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		handleRequest(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != status {
+			t.Errorf("Expected %d for %s, got %d", status, path, w.Code)
+		}
+	}
+
+	// Readers that can't be walked leave no index
+	databases = map[string]*dbConfig{"asn": {reader: &MockReader{}}}
+	buildASNIndex()
+	w := httptest.NewRecorder()
+	handleRequest(w, httptest.NewRequest("GET", "/asn/20712", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without a walkable ASN database, got %d", w.Code)
+	}
+}
+
+func TestASNIndexRebuiltOnReload(t *testing.T) {
+	originalDatabases := databases
+	originalOpen := geoipOpen
+	defer func() {
+		databases = originalDatabases
+		geoipOpen = originalOpen
+		buildASNIndex()
+	}()
+
+	path := filepath.Join(t.TempDir(), "GeoLite2-ASN.mmdb")
+	if err := os.WriteFile(path, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	geoipOpen = func(string) (Reader, error) {
+		return &walkableReader{networks: []fakeNetwork{
+			{"192.0.2.0/24", geoip2.ASN{AutonomousSystemNumber: 64496, AutonomousSystemOrganization: "New"}},
+		}}, nil
+	}
+	databases = map[string]*dbConfig{"asn": {
+		reader:     &walkableReader{},
+		localPath:  path,
+		lastUpdate: time.Now().Add(-time.Hour),
+	}}
+	buildASNIndex()
+
+	reloadChangedDatabases()
+	waitForIndexes()
+
+	asnIndexMutex.RLock()
+	org := asnIdx.orgs[64496]
+	asnIndexMutex.RUnlock()
+	if org != "New" {
+		t.Errorf("Expected index to be rebuilt from the new database, got %q", org)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// setupCLI prepares a subcommand that looks addresses up without starting
+// the server: it loads the configuration and opens the databases in dbDir,
+// downloading missing ones only if download is set
+func setupCLI(download bool) error {
+	if err := loadEffectiveConfig(false, false); err != nil {
+		return err
+	}
+	if err := validateConfig(config); err != nil {
+		return err
+	}
+
+	// Only warnings go to stderr unless more is asked for, and there are
+	// no requests to log
+	logConfig := config.Log
+	logConfig.AccessLog = false
+	if logConfig.Level == "" || strings.EqualFold(logConfig.Level, "info") {
+		logConfig.Level = "warn"
+	}
+	if err := setupLogging(logConfig); err != nil {
+		return err
+	}
+	setupDNS(config.DNS)
+
+	offline := config.Offline
+	if !download {
+		config.Offline = true
+	}
+	configureDatabases(config)
+	if !config.Offline {
+		if err := os.MkdirAll(dbDir, 0755); err != nil {
+			return err
+		}
+	}
+
+	// The indexes only serve /asn and /search and take a whole database
+	// walk to build
+	databaseIndexes = nil
+	if err := initDatabases(); err != nil {
+		if !offline && !download {
+			return fmt.Errorf("%v; pass -download to download them", err)
+		}
+		return err
+	}
+	return reloadOverrides()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Default header trusted proxies put the client address in
+const defaultClientIPHeader = "X-Forwarded-For"
+
+// trustedProxies holds the networks of Config.TrustedProxies. Without any,
+// the connection's address is the client address.
+var trustedProxies *prefixTable[struct{}]
+
+// parseTrustedProxies builds the table of trusted proxy networks
+func parseTrustedProxies(values []string) (*prefixTable[struct{}], error) {
+	proxies := newPrefixTable[struct{}]()
+	for _, value := range values {
+		prefix, err := parsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", value)
+		}
+		proxies.set(prefix, struct{}{})
+	}
+	return proxies, nil
+}
+
+// setupTrustedProxies builds the trusted proxy table from the configuration
+func setupTrustedProxies(cfg Config) error {
+	proxies, err := parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	trustedProxies = proxies
+	return nil
+}
+
+// getClientIP returns the address a request came from. The client IP
+// header is only believed when the connection comes from a trusted proxy,
+// and then read from the right: proxies append the address they received
+// the request from, so the rightmost address that isn't a trusted proxy is
+// the first one a client can't have made up.
+func getClientIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if !isTrustedProxy(remote) {
+		return remote
+	}
+
+	header := config.ClientIPHeader
+	if header == "" {
+		header = defaultClientIPHeader
+	}
+	var addresses []string
+	for _, value := range r.Header.Values(header) {
+		for _, address := range strings.Split(value, ",") {
+			if address = strings.TrimSpace(address); address != "" {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	if len(addresses) == 0 {
+		return remote
+	}
+	for i := len(addresses) - 1; i > 0; i-- {
+		if !isTrustedProxy(addresses[i]) {
+			return addresses[i]
+		}
+	}
+	return addresses[0]
+}
+
+// isTrustedProxy reports whether address is in trusted_proxies
+func isTrustedProxy(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil || trustedProxies == nil {
+		return false
+	}
+	_, ok := trustedProxies.lookup(ip)
+	return ok
+}
+
+// checkTrustedProxies validates trusted_proxies
+func (v *configValidator) checkTrustedProxies(cfg Config) {
+	if _, err := parseTrustedProxies(cfg.TrustedProxies); err != nil {
+		v.addf("trusted_proxies", "%v", err)
+	}
+}
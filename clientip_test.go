@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// trustProxies configures trusted proxies for the rest of the test
+func trustProxies(t *testing.T, proxies ...string) {
+	t.Helper()
+	originalProxies, originalConfigProxies := trustedProxies, config.TrustedProxies
+	t.Cleanup(func() { trustedProxies, config.TrustedProxies = originalProxies, originalConfigProxies })
+	config.TrustedProxies = proxies
+	if err := setupTrustedProxies(config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetClientIPTrustedProxies(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+	config = defaultConfig
+
+	request := func(remoteAddr string, headers ...string) *http.Request {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		for i := 0; i+1 < len(headers); i += 2 {
+			r.Header.Add(headers[i], headers[i+1])
+		}
+		return r
+	}
+
+	// Without trusted proxies the header is ignored
+	trustProxies(t)
+	if got := getClientIP(request("198.51.100.7:4000", "X-Forwarded-For", "2.160.0.1")); got != "198.51.100.7" {
+		t.Errorf("Expected the connection's address, got %s", got)
+	}
+
+	trustProxies(t, "10.0.0.0/8", "192.0.2.1")
+	for _, test := range []struct {
+		describe   string
+		remoteAddr string
+		headers    []string
+		expected   string
+	}{
+		{"untrusted connection", "198.51.100.7:4000", []string{"X-Forwarded-For", "2.160.0.1"}, "198.51.100.7"},
+		{"proxy without header", "192.0.2.1:4000", nil, "192.0.2.1"},
+		{"single proxy", "192.0.2.1:4000", []string{"X-Forwarded-For", "8.8.8.8"}, "8.8.8.8"},
+		{"spoofed leftmost entry", "192.0.2.1:4000", []string{"X-Forwarded-For", "2.160.0.1, 8.8.8.8"}, "8.8.8.8"},
+		{"proxy chain", "10.0.0.2:4000", []string{"X-Forwarded-For", "2.160.0.1, 8.8.8.8, 10.0.0.1"}, "8.8.8.8"},
+		{"repeated headers", "192.0.2.1:4000", []string{"X-Forwarded-For", "2.160.0.1", "X-Forwarded-For", "8.8.8.8"}, "8.8.8.8"},
+		{"only proxies", "192.0.2.1:4000", []string{"X-Forwarded-For", "10.0.0.5, 10.0.0.1"}, "10.0.0.5"},
+		{"other header ignored", "192.0.2.1:4000", []string{"X-Real-IP", "2.160.0.1"}, "192.0.2.1"},
+	} {
+		if got := getClientIP(request(test.remoteAddr, test.headers...)); got != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.describe, test.expected, got)
+		}
+	}
+
+	config.ClientIPHeader = "X-Real-IP"
+	if got := getClientIP(request("192.0.2.1:4000", "X-Real-IP", "2.160.0.1", "X-Forwarded-For", "8.8.8.8")); got != "2.160.0.1" {
+		t.Errorf("Expected the configured header, got %s", got)
+	}
+}
+
+func TestValidateTrustedProxies(t *testing.T) {
+	cfg := defaultConfig
+	cfg.TrustedProxies = []string{"10.0.0.0/8", "10.0.0.0/33"}
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), `trusted_proxies: invalid trusted proxy "10.0.0.0/33"`) {
+		t.Errorf("Expected invalid trusted proxy, got %v", err)
+	}
+}
+
+func TestForwardAuthMatchesAccessLog(t *testing.T) {
+	setupPolicies(t, testPolicies)
+	originalPolicy := forwardAuthPolicy
+	originalAccessLogger := accessLogger
+	originalLogCfg := logCfg
+	defer func() {
+		forwardAuthPolicy = originalPolicy
+		accessLogger = originalAccessLogger
+		logCfg = originalLogCfg
+	}()
+
+	var buf bytes.Buffer
+	accessLogger = slog.New(slog.NewJSONHandler(&buf, nil))
+	logCfg = LogConfig{AccessLog: true, SampleRate: 1}
+	config.ForwardAuth = ForwardAuthConfig{Enabled: true, AllowCountries: []string{"US"}}
+	if err := setupForwardAuth(config.ForwardAuth); err != nil {
+		t.Fatal(err)
+	}
+	trustProxies(t, "192.0.2.1")
+	handler := withAccessLog(http.HandlerFunc(handleRequest))
+
+	for _, test := range []struct {
+		describe   string
+		remoteAddr string
+		forwarded  string
+		clientIP   string
+		country    string
+	}{
+		{"trusted proxy", "192.0.2.1:4000", "2.160.0.1, 8.8.8.8", "8.8.8.8", "US"},
+		{"spoofing client", "81.2.69.142:4000", "8.8.8.8", "81.2.69.142", "GB"},
+	} {
+		buf.Reset()
+		r := httptest.NewRequest("GET", "/auth", nil)
+		r.RemoteAddr = test.remoteAddr
+		r.Header.Set("X-Forwarded-For", test.forwarded)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		var entry map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("%s: failed to parse access log line %q: %v", test.describe, buf.String(), err)
+		}
+		if entry["client_ip"] != test.clientIP || w.Header().Get(headerGeoCountry) != test.country {
+			t.Errorf("%s: expected /auth and the access log to use %s (%s), got %v and %s",
+				test.describe, test.clientIP, test.country, entry["client_ip"], w.Header().Get(headerGeoCountry))
+		}
+	}
+}
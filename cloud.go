@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CloudRangeSource configures a provider's published IP range file
+type CloudRangeSource struct {
+	File string `json:"file"` // Local path of the range file
+	URL  string `json:"url"`  // Where to refresh the file from; empty to manage it yourself
+}
+
+// cloudRange is what a cloud range lookup reports
+type cloudRange struct {
+	Provider string
+	Service  string
+	Region   string
+}
+
+// cloudPrefix is a prefix read from a provider's range file
+type cloudPrefix struct {
+	prefix  string
+	service string
+	region  string
+}
+
+// Range file parsers by provider name
+var cloudProviders = map[string]func(data []byte) ([]cloudPrefix, error){
+	"aws":          parseAWSRanges,
+	"gcp":          parseGCPRanges,
+	"azure":        parseAzureRanges,
+	"cloudflare":   parseCloudflareRanges,
+	"fastly":       parseFastlyRanges,
+	"oracle":       parseOracleRanges,
+	"digitalocean": parseDigitalOceanRanges,
+}
+
+// Services that cover a provider's whole address space. Prefixes listed
+// for both a generic and a specific service report the specific one.
+var genericCloudServices = map[string]bool{"": true, "AMAZON": true}
+
+var (
+	cloudMutex    sync.RWMutex
+	cloudTable    *prefixTable[cloudRange] // nil when no range files are configured
+	cloudModTimes map[string]time.Time     // Modification time of each loaded file by provider
+)
+
+// lookupCloud fills in the cloud provider fields for ip
+func lookupCloud(info *IPInfo, ip net.IP) {
+	cloudMutex.RLock()
+	table := cloudTable
+	cloudMutex.RUnlock()
+
+	if table == nil {
+		info.omitDatabase("cloud")
+		return
+	}
+	if r, ok := table.lookup(ip); ok {
+		info.CloudProvider = r.Provider
+		info.CloudService = r.Service
+		info.CloudRegion = r.Region
+	}
+}
+
+// initCloudRanges downloads missing range files and loads them
+func initCloudRanges() error {
+	for provider, source := range config.CloudRanges {
+		if _, err := os.Stat(source.File); err == nil || source.URL == "" || config.Offline {
+			continue
+		}
+		logger.Info("Cloud range file not found, downloading", "provider", provider, "path", source.File)
+		if err := downloadCloudRanges(source); err != nil {
+			return fmt.Errorf("%s: %v", provider, err)
+		}
+	}
+	return reloadCloudRanges()
+}
+
+// refreshCloudRanges downloads range files older than update_max_age and
+// reloads the ranges if any file changed. It runs from the database updater.
+func refreshCloudRanges() {
+	maxAge := time.Duration(config.UpdateMaxAge)
+	if maxAge <= 0 {
+		maxAge = time.Duration(defaultConfig.UpdateMaxAge)
+	}
+
+	changed := false
+	for provider, source := range config.CloudRanges {
+		info, err := os.Stat(source.File)
+		if source.URL != "" && (err != nil || time.Since(info.ModTime()) >= maxAge) {
+			logger.Info("Cloud range file is outdated, updating", "provider", provider, "max_age", maxAge)
+			if err := downloadCloudRanges(source); err != nil {
+				logger.Error("Failed to download cloud ranges", "provider", provider, "error", err)
+				continue
+			}
+			info, err = os.Stat(source.File)
+		}
+
+		cloudMutex.RLock()
+		loaded := cloudModTimes[provider]
+		cloudMutex.RUnlock()
+		if err == nil && !info.ModTime().Equal(loaded) {
+			changed = true
+		}
+	}
+
+	if changed {
+		if err := reloadCloudRanges(); err != nil {
+			logger.Error("Failed to reload cloud ranges", "error", err)
+		}
+	}
+}
+
+// downloadCloudRanges replaces a range file with a fresh copy
+func downloadCloudRanges(source CloudRangeSource) error {
+	tempPath := source.File + ".new"
+	if err := downloadDatabase(source.URL, tempPath, nil); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, source.File)
+}
+
+// reloadCloudRanges loads every configured range file into a new table and
+// swaps it in. On error the previous ranges stay active.
+func reloadCloudRanges() error {
+	if len(config.CloudRanges) == 0 {
+		cloudMutex.Lock()
+		cloudTable, cloudModTimes = nil, nil
+		cloudMutex.Unlock()
+		return nil
+	}
+
+	table := newPrefixTable[cloudRange]()
+	modTimes := make(map[string]time.Time)
+
+	// Sorted so that overlapping prefixes resolve the same way every time
+	providers := make([]string, 0, len(config.CloudRanges))
+	for provider := range config.CloudRanges {
+		providers = append(providers, provider)
+	}
+	sort.Strings(providers)
+
+	for _, provider := range providers {
+		source := config.CloudRanges[provider]
+		info, err := os.Stat(source.File)
+		if err != nil {
+			return fmt.Errorf("%s: %v", provider, err)
+		}
+		prefixes, err := loadCloudRangeFile(provider, source.File)
+		if err != nil {
+			return err
+		}
+		for _, p := range prefixes {
+			prefix, err := parsePrefix(p.prefix)
+			if err != nil {
+				return fmt.Errorf("%s: %s: invalid prefix %q", provider, source.File, p.prefix)
+			}
+			if existing, ok := table.get(prefix); ok && !genericCloudServices[existing.Service] {
+				continue
+			}
+			table.set(prefix, cloudRange{Provider: provider, Service: p.service, Region: p.region})
+		}
+		modTimes[provider] = info.ModTime()
+		logger.Info("Loaded cloud ranges", "provider", provider, "path", source.File, "prefixes", len(prefixes))
+	}
+
+	cloudMutex.Lock()
+	cloudTable, cloudModTimes = table, modTimes
+	cloudMutex.Unlock()
+	return nil
+}
+
+// loadCloudRangeFile reads and parses a provider's range file
+func loadCloudRangeFile(provider, path string) ([]cloudPrefix, error) {
+	parse, ok := cloudProviders[provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown cloud provider %q", provider)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", provider, err)
+	}
+	prefixes, err := parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s: %v", provider, path, err)
+	}
+	return prefixes, nil
+}
+
+// parseAWSRanges reads https://ip-ranges.amazonaws.com/ip-ranges.json
+func parseAWSRanges(data []byte) ([]cloudPrefix, error) {
+	var ranges struct {
+		Prefixes []struct {
+			IPPrefix string `json:"ip_prefix"`
+			Region   string `json:"region"`
+			Service  string `json:"service"`
+		} `json:"prefixes"`
+		IPv6Prefixes []struct {
+			IPv6Prefix string `json:"ipv6_prefix"`
+			Region     string `json:"region"`
+			Service    string `json:"service"`
+		} `json:"ipv6_prefixes"`
+	}
+	if err := json.Unmarshal(data, &ranges); err != nil {
+		return nil, err
+	}
+
+	var prefixes []cloudPrefix
+	for _, p := range ranges.Prefixes {
+		prefixes = append(prefixes, cloudPrefix{p.IPPrefix, p.Service, p.Region})
+	}
+	for _, p := range ranges.IPv6Prefixes {
+		prefixes = append(prefixes, cloudPrefix{p.IPv6Prefix, p.Service, p.Region})
+	}
+	return prefixes, nil
+}
+
+// parseGCPRanges reads https://www.gstatic.com/ipranges/cloud.json
+func parseGCPRanges(data []byte) ([]cloudPrefix, error) {
+	var ranges struct {
+		Prefixes []struct {
+			IPv4Prefix string `json:"ipv4Prefix"`
+			IPv6Prefix string `json:"ipv6Prefix"`
+			Service    string `json:"service"`
+			Scope      string `json:"scope"`
+		} `json:"prefixes"`
+	}
+	if err := json.Unmarshal(data, &ranges); err != nil {
+		return nil, err
+	}
+
+	var prefixes []cloudPrefix
+	for _, p := range ranges.Prefixes {
+		prefix := p.IPv4Prefix
+		if prefix == "" {
+			prefix = p.IPv6Prefix
+		}
+		prefixes = append(prefixes, cloudPrefix{prefix, p.Service, p.Scope})
+	}
+	return prefixes, nil
+}
+
+// parseAzureRanges reads the weekly ServiceTags_Public_*.json download
+func parseAzureRanges(data []byte) ([]cloudPrefix, error) {
+	var ranges struct {
+		Values []struct {
+			Properties struct {
+				Region          string   `json:"region"`
+				SystemService   string   `json:"systemService"`
+				AddressPrefixes []string `json:"addressPrefixes"`
+			} `json:"properties"`
+		} `json:"values"`
+	}
+	if err := json.Unmarshal(data, &ranges); err != nil {
+		return nil, err
+	}
+
+	var prefixes []cloudPrefix
+	for _, value := range ranges.Values {
+		for _, prefix := range value.Properties.AddressPrefixes {
+			prefixes = append(prefixes, cloudPrefix{prefix, value.Properties.SystemService, value.Properties.Region})
+		}
+	}
+	return prefixes, nil
+}
+
+// parseCloudflareRanges reads https://api.cloudflare.com/client/v4/ips or
+// the plain text https://www.cloudflare.com/ips-v4 and ips-v6 lists
+func parseCloudflareRanges(data []byte) ([]cloudPrefix, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return parsePlainRanges(data, "CDN")
+	}
+
+	var ranges struct {
+		Result struct {
+			IPv4CIDRs []string `json:"ipv4_cidrs"`
+			IPv6CIDRs []string `json:"ipv6_cidrs"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(data, &ranges); err != nil {
+		return nil, err
+	}
+
+	var prefixes []cloudPrefix
+	for _, prefix := range append(ranges.Result.IPv4CIDRs, ranges.Result.IPv6CIDRs...) {
+		prefixes = append(prefixes, cloudPrefix{prefix, "CDN", ""})
+	}
+	return prefixes, nil
+}
+
+// parseFastlyRanges reads https://api.fastly.com/public-ip-list
+func parseFastlyRanges(data []byte) ([]cloudPrefix, error) {
+	var ranges struct {
+		Addresses     []string `json:"addresses"`
+		IPv6Addresses []string `json:"ipv6_addresses"`
+	}
+	if err := json.Unmarshal(data, &ranges); err != nil {
+		return nil, err
+	}
+
+	var prefixes []cloudPrefix
+	for _, prefix := range append(ranges.Addresses, ranges.IPv6Addresses...) {
+		prefixes = append(prefixes, cloudPrefix{prefix, "CDN", ""})
+	}
+	return prefixes, nil
+}
+
+// parseOracleRanges reads https://docs.oracle.com/iaas/tools/public_ip_ranges.json
+func parseOracleRanges(data []byte) ([]cloudPrefix, error) {
+	var ranges struct {
+		Regions []struct {
+			Region string `json:"region"`
+			CIDRs  []struct {
+				CIDR string   `json:"cidr"`
+				Tags []string `json:"tags"`
+			} `json:"cidrs"`
+		} `json:"regions"`
+	}
+	if err := json.Unmarshal(data, &ranges); err != nil {
+		return nil, err
+	}
+
+	var prefixes []cloudPrefix
+	for _, region := range ranges.Regions {
+		for _, cidr := range region.CIDRs {
+			prefixes = append(prefixes, cloudPrefix{cidr.CIDR, strings.Join(cidr.Tags, ","), region.Region})
+		}
+	}
+	return prefixes, nil
+}
+
+// parseDigitalOceanRanges reads https://digitalocean.com/geo/google.csv,
+// whose columns are prefix, country, region code, city and postal code
+func parseDigitalOceanRanges(data []byte) ([]cloudPrefix, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+
+	var prefixes []cloudPrefix
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return prefixes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) == 0 || record[0] == "" {
+			continue
+		}
+		var region string
+		if len(record) > 3 {
+			region = record[3]
+		}
+		prefixes = append(prefixes, cloudPrefix{record[0], "", region})
+	}
+}
+
+// parsePlainRanges reads one prefix per line, skipping blank lines and
+// # comments
+func parsePlainRanges(data []byte, service string) ([]cloudPrefix, error) {
+	var prefixes []cloudPrefix
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			prefixes = append(prefixes, cloudPrefix{line, service, ""})
+		}
+	}
+	return prefixes, scanner.Err()
+}
+
+// checkCloudRanges validates the cloud_ranges section
+func (v *configValidator) checkCloudRanges(sources map[string]CloudRangeSource) {
+	for provider, source := range sources {
+		field := "cloud_ranges." + provider
+		if _, ok := cloudProviders[provider]; !ok {
+			v.addf(field, "unknown provider, expected one of %s", strings.Join(knownCloudProviders(), ", "))
+			continue
+		}
+		if source.File == "" {
+			v.addf(field+".file", "must not be empty")
+		}
+		if source.URL != "" {
+			v.url(field+".url", source.URL)
+		} else if source.File != "" {
+			v.fileExists(field+".file", source.File)
+		}
+	}
+}
+
+func knownCloudProviders() []string {
+	providers := make([]string, 0, len(cloudProviders))
+	for provider := range cloudProviders {
+		providers = append(providers, provider)
+	}
+	sort.Strings(providers)
+	return providers
+}
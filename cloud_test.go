@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Trimmed copies of the providers' published range files
+var cloudFixtures = map[string]string{
+	"aws": `{"syncToken": "1", "prefixes": [
+		{"ip_prefix": "3.5.140.0/22", "region": "ap-northeast-2", "service": "AMAZON", "network_border_group": "ap-northeast-2"},
+		{"ip_prefix": "3.5.140.0/22", "region": "ap-northeast-2", "service": "S3", "network_border_group": "ap-northeast-2"},
+		{"ip_prefix": "52.94.0.0/16", "region": "us-east-1", "service": "AMAZON", "network_border_group": "us-east-1"}
+	], "ipv6_prefixes": [
+		{"ipv6_prefix": "2600:1f14::/35", "region": "us-west-2", "service": "EC2", "network_border_group": "us-west-2"}
+	]}`,
+	"gcp": `{"prefixes": [
+		{"ipv4Prefix": "34.80.0.0/15", "service": "Google Cloud", "scope": "asia-east1"},
+		{"ipv6Prefix": "2600:1900:4000::/44", "service": "Google Cloud", "scope": "us-central1"}
+	]}`,
+	"azure": `{"values": [
+		{"name": "AzureCloud.westeurope", "properties": {"region": "westeurope", "systemService": "", "addressPrefixes": ["13.69.0.0/17"]}},
+		{"name": "Storage.WestEurope", "properties": {"region": "westeurope", "systemService": "AzureStorage", "addressPrefixes": ["13.69.40.0/24"]}}
+	]}`,
+	"cloudflare":   "# https://www.cloudflare.com/ips-v4\n104.16.0.0/13\n2606:4700::/32\n",
+	"fastly":       `{"addresses": ["151.101.0.0/16"], "ipv6_addresses": ["2a04:4e40::/32"]}`,
+	"oracle":       `{"regions": [{"region": "us-phoenix-1", "cidrs": [{"cidr": "129.146.0.0/21", "tags": ["OCI"]}]}]}`,
+	"digitalocean": "5.101.96.0/21,NL,NL-NH,Amsterdam,1098 XG\n2a03:b0c0:2::/48,NL,NL-NH,Amsterdam,1098 XG\n",
+}
+
+func writeCloudFixtures(t *testing.T) map[string]CloudRangeSource {
+	t.Helper()
+	dir := t.TempDir()
+	sources := make(map[string]CloudRangeSource)
+	for provider, content := range cloudFixtures {
+		path := filepath.Join(dir, provider+".json")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write fixture: %v", err)
+		}
+		sources[provider] = CloudRangeSource{File: path}
+	}
+	return sources
+}
+
+func TestCloudRanges(t *testing.T) {
+	originalConfig := config
+	defer func() {
+		config = originalConfig
+		reloadCloudRanges()
+	}()
+
+	config.CloudRanges = writeCloudFixtures(t)
+	if err := reloadCloudRanges(); err != nil {
+		t.Fatalf("reloadCloudRanges failed: %v", err)
+	}
+
+	tests := []struct {
+		ip                        string
+		provider, service, region string
+	}{
+		{"3.5.141.1", "aws", "S3", "ap-northeast-2"},
+		{"52.94.1.1", "aws", "AMAZON", "us-east-1"},
+		{"2600:1f14::1", "aws", "EC2", "us-west-2"},
+		{"34.81.0.1", "gcp", "Google Cloud", "asia-east1"},
+		{"2600:1900:4000::1", "gcp", "Google Cloud", "us-central1"},
+		{"13.69.40.1", "azure", "AzureStorage", "westeurope"},
+		{"13.69.1.1", "azure", "", "westeurope"},
+		{"104.17.0.1", "cloudflare", "CDN", ""},
+		{"2606:4700::1", "cloudflare", "CDN", ""},
+		{"151.101.1.1", "fastly", "CDN", ""},
+		{"129.146.1.1", "oracle", "OCI", "us-phoenix-1"},
+		{"5.101.97.1", "digitalocean", "", "Amsterdam"},
+		{"81.2.69.142", "", "", ""},
+	}
+	for _, tt := range tests {
+		info := &IPInfo{}
+		lookupCloud(info, net.ParseIP(tt.ip))
+		if info.CloudProvider != tt.provider || info.CloudService != tt.service || info.CloudRegion != tt.region {
+			t.Errorf("%s: got %q/%q/%q, expected %q/%q/%q", tt.ip, info.CloudProvider, info.CloudService,
+				info.CloudRegion, tt.provider, tt.service, tt.region)
+		}
+	}
+
+	// A broken file keeps the previous ranges
+	os.WriteFile(config.CloudRanges["fastly"].File, []byte("{"), 0644)
+	if err := reloadCloudRanges(); err == nil {
+		t.Error("Expected error for broken range file")
+	}
+	info := &IPInfo{}
+	lookupCloud(info, net.ParseIP("151.101.1.1"))
+	if info.CloudProvider != "fastly" {
+		t.Errorf("Expected previous ranges to stay active, got %q", info.CloudProvider)
+	}
+
+	// Without range files the cloud fields are left out
+	config.CloudRanges = nil
+	reloadCloudRanges()
+	info = &IPInfo{}
+	lookupCloud(info, net.ParseIP("151.101.1.1"))
+	if !info.omit["cloud_provider"] {
+		t.Error("Expected cloud fields to be omitted without range files")
+	}
+}
+
+func TestRefreshCloudRanges(t *testing.T) {
+	originalConfig := config
+	defer func() {
+		config = originalConfig
+		reloadCloudRanges()
+	}()
+
+	ranges := `{"addresses": ["151.101.0.0/16"], "ipv6_addresses": []}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(ranges))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "fastly.json")
+	config.Offline = false
+	config.UpdateMaxAge = Duration(time.Hour)
+	config.CloudRanges = map[string]CloudRangeSource{"fastly": {File: path, URL: server.URL}}
+
+	// Missing files are downloaded at startup
+	if err := initCloudRanges(); err != nil {
+		t.Fatalf("initCloudRanges failed: %v", err)
+	}
+	info := &IPInfo{}
+	lookupCloud(info, net.ParseIP("151.101.1.1"))
+	if info.CloudProvider != "fastly" {
+		t.Fatalf("Expected downloaded ranges to be loaded, got %q", info.CloudProvider)
+	}
+
+	// Outdated files are downloaded again and reloaded
+	ranges = `{"addresses": ["199.232.0.0/16"]}`
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(path, old, old)
+	refreshCloudRanges()
+
+	info = &IPInfo{}
+	lookupCloud(info, net.ParseIP("199.232.1.1"))
+	if info.CloudProvider != "fastly" {
+		t.Errorf("Expected refreshed ranges to be loaded, got %q", info.CloudProvider)
+	}
+}
+
+func TestValidateCloudRanges(t *testing.T) {
+	cfg := defaultConfig
+	cfg.CloudRanges = map[string]CloudRangeSource{
+		"aws":     {File: "/nonexistent/ip-ranges.json"},
+		"gcp":     {File: "cloud.json", URL: "gstatic.com/ipranges/cloud.json"},
+		"hetzner": {File: "hetzner.json"},
+		"fastly":  {URL: "https://api.fastly.com/public-ip-list"},
+	}
+
+	err := validateConfig(cfg)
+	for _, expected := range []string{"cloud_ranges.aws.file", "cloud_ranges.gcp.url", "cloud_ranges.hetzner: unknown provider", "cloud_ranges.fastly.file"} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected problem %q, got %v", expected, err)
+		}
+	}
+}
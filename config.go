@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Prefix of environment variables overriding configuration values
+const envPrefix = "GEOIP_API_"
+
+// Placeholder written instead of secret configuration values
+const redactedValue = "[REDACTED]"
+
+// Duration is a time.Duration stored in the configuration file in its
+// string form, e.g. "24h"
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	value, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(value)
+	return nil
+}
+
+// configField describes a single overridable configuration value
+type configField struct {
+	path  []string // JSON names from the Config root to the field
+	value reflect.Value
+}
+
+// envName returns the environment variable overriding the field
+func (f configField) envName() string {
+	return envPrefix + strings.ToUpper(strings.Join(f.path, "_"))
+}
+
+// flagName returns the command line flag overriding the field
+func (f configField) flagName() string {
+	return strings.ReplaceAll(strings.Join(f.path, "-"), "_", "-")
+}
+
+// Name used in error messages, e.g. "log.level"
+func (f configField) String() string {
+	return strings.Join(f.path, ".")
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// configFields lists every scalar field of cfg that can be overridden from
+// the environment or the command line. Nested structs are flattened; maps and
+// slices other than string lists can only be set in the configuration file.
+func configFields(cfg *Config) []configField {
+	var fields []configField
+	collectConfigFields(reflect.ValueOf(cfg).Elem(), nil, &fields)
+	return fields
+}
+
+func collectConfigFields(v reflect.Value, path []string, fields *[]configField) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+
+		fieldPath := append(append([]string{}, path...), name)
+		value := v.Field(i)
+
+		if value.Kind() == reflect.Struct && !reflect.PointerTo(value.Type()).Implements(textUnmarshalerType) {
+			collectConfigFields(value, fieldPath, fields)
+			continue
+		}
+		if isOverridable(value) {
+			*fields = append(*fields, configField{path: fieldPath, value: value})
+		}
+	}
+}
+
+// isOverridable reports whether a value can be parsed from a single string
+func isOverridable(v reflect.Value) bool {
+	if reflect.PointerTo(v.Type()).Implements(textUnmarshalerType) {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.String, reflect.Bool, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	case reflect.Slice:
+		return v.Type().Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+// setFieldValue parses raw and stores it in v
+func setFieldValue(v reflect.Value, raw string) error {
+	if unmarshaler, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(raw))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// applyEnvOverrides sets configuration values from GEOIP_API_* variables
+func applyEnvOverrides(cfg *Config, lookup func(string) (string, bool)) error {
+	for _, field := range configFields(cfg) {
+		raw, ok := lookup(field.envName())
+		if !ok {
+			continue
+		}
+		if err := setFieldValue(field.value, raw); err != nil {
+			return fmt.Errorf("invalid value for %s: %v", field.envName(), err)
+		}
+	}
+	return nil
+}
+
+// configFlag is a flag.Value remembering the raw value given on the command
+// line, so that flags can be applied after the configuration file is read
+type configFlag struct {
+	isBool bool
+	value  *string
+}
+
+func (f *configFlag) String() string {
+	if f.value == nil || *f.value == "" {
+		return ""
+	}
+	return *f.value
+}
+
+func (f *configFlag) Set(raw string) error {
+	*f.value = raw
+	return nil
+}
+
+func (f *configFlag) IsBoolFlag() bool {
+	return f.isBool
+}
+
+// Raw values of configuration flags, keyed by flag name
+var configFlagValues = map[string]*string{}
+
+// registerConfigFlags defines a command line flag for every overridable field
+func registerConfigFlags(fs *flag.FlagSet) {
+	for _, field := range configFields(&Config{}) {
+		value := new(string)
+		configFlagValues[field.flagName()] = value
+		fs.Var(&configFlag{isBool: field.value.Kind() == reflect.Bool, value: value},
+			field.flagName(), fmt.Sprintf("Override %s (env %s)", field, field.envName()))
+	}
+}
+
+// applyFlagOverrides sets configuration values from flags given on the
+// command line
+func applyFlagOverrides(cfg *Config, fs *flag.FlagSet) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	for _, field := range configFields(cfg) {
+		name := field.flagName()
+		if !set[name] {
+			continue
+		}
+		if err := setFieldValue(field.value, *configFlagValues[name]); err != nil {
+			return fmt.Errorf("invalid value for -%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// redactSecrets replaces every string field tagged `secret:"true"` with a
+// placeholder. Secret maps keep their keys and have their values redacted.
+func redactSecrets(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			redactSecrets(v.Elem())
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if !t.Field(i).IsExported() {
+				continue
+			}
+			field := v.Field(i)
+			if t.Field(i).Tag.Get("secret") == "true" {
+				redactValue(field)
+				continue
+			}
+			redactSecrets(field)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			redactSecrets(v.Index(i))
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			// Map values aren't addressable, so redact a copy and store it back
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(key))
+			redactSecrets(elem)
+			v.SetMapIndex(key, elem)
+		}
+	}
+}
+
+// redactValue blanks out a secret value
+func redactValue(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.String() != "" {
+			v.SetString(redactedValue)
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			return
+		}
+		for _, key := range v.MapKeys() {
+			v.SetMapIndex(key, reflect.ValueOf(redactedValue).Convert(v.Type().Elem()))
+		}
+	}
+}
+
+// printConfig writes the effective configuration as JSON with secrets redacted
+func printConfig(w io.Writer, cfg Config) error {
+	// Round-trip through JSON to get a deep copy that can be redacted safely
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	var redacted Config
+	if err := json.Unmarshal(data, &redacted); err != nil {
+		return err
+	}
+	redactSecrets(reflect.ValueOf(&redacted))
+
+	data, err = json.MarshalIndent(redacted, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// lookupEnv is os.LookupEnv, replaceable in tests
+var lookupEnv = os.LookupEnv
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDurationJSON(t *testing.T) {
+	var cfg struct {
+		Interval Duration `json:"interval"`
+	}
+
+	if err := json.Unmarshal([]byte(`{"interval": "90m"}`), &cfg); err != nil {
+		t.Fatalf("Failed to unmarshal duration: %v", err)
+	}
+	if time.Duration(cfg.Interval) != 90*time.Minute {
+		t.Errorf("Expected 90m, got %v", time.Duration(cfg.Interval))
+	}
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("Failed to marshal duration: %v", err)
+	}
+	if string(data) != `{"interval":"1h30m0s"}` {
+		t.Errorf("Unexpected JSON for duration: %s", data)
+	}
+
+	if err := json.Unmarshal([]byte(`{"interval": "soon"}`), &cfg); err == nil {
+		t.Error("Expected error for invalid duration, got nil")
+	}
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	cfg := defaultConfig
+	env := map[string]string{
+		"GEOIP_API_HOST":            "geo.example.com",
+		"GEOIP_API_PORT":            "8081",
+		"GEOIP_API_SSL":             "true",
+		"GEOIP_API_DB_DIR":          "/var/lib/geoip",
+		"GEOIP_API_UPDATE_INTERVAL": "6h",
+		"GEOIP_API_LOG_LEVEL":       "debug",
+		"GEOIP_API_LOG_SAMPLE_RATE": "0.25",
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	if err := applyEnvOverrides(&cfg, lookup); err != nil {
+		t.Fatalf("applyEnvOverrides failed: %v", err)
+	}
+
+	if cfg.Host != "geo.example.com" || cfg.Port != "8081" || !cfg.SSL {
+		t.Errorf("Server settings were not overridden: %+v", cfg)
+	}
+	if cfg.DBDir != "/var/lib/geoip" {
+		t.Errorf("Expected db_dir '/var/lib/geoip', got '%s'", cfg.DBDir)
+	}
+	if time.Duration(cfg.UpdateInterval) != 6*time.Hour {
+		t.Errorf("Expected update interval 6h, got %v", time.Duration(cfg.UpdateInterval))
+	}
+	if cfg.Log.Level != "debug" || cfg.Log.SampleRate != 0.25 {
+		t.Errorf("Log settings were not overridden: %+v", cfg.Log)
+	}
+
+	// Values not present in the environment are left alone
+	if cfg.UpdateMaxAge != defaultConfig.UpdateMaxAge {
+		t.Errorf("Expected update max age to keep its default, got %v", time.Duration(cfg.UpdateMaxAge))
+	}
+
+	env = map[string]string{"GEOIP_API_SSL": "maybe"}
+	if err := applyEnvOverrides(&cfg, lookup); err == nil || !strings.Contains(err.Error(), "GEOIP_API_SSL") {
+		t.Errorf("Expected error naming GEOIP_API_SSL, got %v", err)
+	}
+}
+
+func TestApplyFlagOverrides(t *testing.T) {
+	originalFlagValues := configFlagValues
+	defer func() { configFlagValues = originalFlagValues }()
+	configFlagValues = map[string]*string{}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	registerConfigFlags(fs)
+
+	if err := fs.Parse([]string{"-port", "7000", "-ssl", "-log-format", "json"}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	// Flags take precedence over the environment
+	cfg := defaultConfig
+	lookup := func(name string) (string, bool) {
+		if name == "GEOIP_API_PORT" {
+			return "8081", true
+		}
+		return "", false
+	}
+	if err := applyEnvOverrides(&cfg, lookup); err != nil {
+		t.Fatalf("applyEnvOverrides failed: %v", err)
+	}
+	if err := applyFlagOverrides(&cfg, fs); err != nil {
+		t.Fatalf("applyFlagOverrides failed: %v", err)
+	}
+
+	if cfg.Port != "7000" {
+		t.Errorf("Expected port '7000' from flag, got '%s'", cfg.Port)
+	}
+	if !cfg.SSL {
+		t.Error("Expected SSL to be enabled by boolean flag")
+	}
+	if cfg.Log.Format != "json" {
+		t.Errorf("Expected log format 'json', got '%s'", cfg.Log.Format)
+	}
+	if cfg.Host != defaultConfig.Host {
+		t.Errorf("Expected host to keep its default, got '%s'", cfg.Host)
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	type source struct {
+		URL     string            `json:"url"`
+		Token   string            `json:"token" secret:"true"`
+		Headers map[string]string `json:"headers" secret:"true"`
+	}
+	value := struct {
+		Sources map[string]*source
+		Empty   string `secret:"true"`
+	}{
+		Sources: map[string]*source{
+			"city": {
+				URL:     "https://example.com/city.mmdb",
+				Token:   "s3cr3t",
+				Headers: map[string]string{"Authorization": "Basic abc"},
+			},
+		},
+	}
+
+	redactSecrets(reflect.ValueOf(&value))
+
+	city := value.Sources["city"]
+	if city.URL != "https://example.com/city.mmdb" {
+		t.Errorf("Non-secret value was modified: %s", city.URL)
+	}
+	if city.Token != redactedValue {
+		t.Errorf("Expected token to be redacted, got '%s'", city.Token)
+	}
+	if city.Headers["Authorization"] != redactedValue {
+		t.Errorf("Expected header value to be redacted, got '%s'", city.Headers["Authorization"])
+	}
+	if value.Empty != "" {
+		t.Errorf("Expected empty secret to stay empty, got '%s'", value.Empty)
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := defaultConfig
+	cfg.Port = "6000"
+
+	if err := printConfig(&buf, cfg); err != nil {
+		t.Fatalf("printConfig failed: %v", err)
+	}
+
+	var printed Config
+	if err := json.Unmarshal(buf.Bytes(), &printed); err != nil {
+		t.Fatalf("printConfig output is not valid JSON: %v", err)
+	}
+	if printed.Port != "6000" {
+		t.Errorf("Expected printed port '6000', got '%s'", printed.Port)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Country metadata keyed by ISO 3166-1 alpha-2 code. The codes, names and
+// flags come from the ISO 3166-1 and ISO 4217 lists; population figures are
+// recent estimates and only meant as a rough indication.
+//
+//go:embed countries.json
+var countriesJSON []byte
+
+// CountryInfo is an entry of the embedded country dataset
+type CountryInfo struct {
+	Code         string   `json:"code"`
+	ISO3         string   `json:"iso3"`
+	Numeric      string   `json:"numeric"`
+	Name         string   `json:"name"`
+	Capital      string   `json:"capital"`
+	CurrencyCode string   `json:"currency_code"`
+	CurrencyName string   `json:"currency_name"`
+	CallingCode  string   `json:"calling_code"`
+	Languages    []string `json:"languages"`
+	TLD          string   `json:"tld"`
+	AreaKm2      float64  `json:"area_km2"`
+	Population   int64    `json:"population"`
+	Flag         string   `json:"flag"`
+}
+
+var (
+	// Countries by alpha-2 code
+	countries = mustLoadCountries(countriesJSON)
+
+	// Alpha-2 codes by alpha-3 code, for lookups by either
+	countryCodesISO3 = indexCountriesISO3(countries)
+)
+
+func mustLoadCountries(data []byte) map[string]*CountryInfo {
+	var list []*CountryInfo
+	if err := json.Unmarshal(data, &list); err != nil {
+		panic("invalid embedded country dataset: " + err.Error())
+	}
+
+	byCode := make(map[string]*CountryInfo, len(list))
+	for _, country := range list {
+		byCode[country.Code] = country
+	}
+	return byCode
+}
+
+func indexCountriesISO3(byCode map[string]*CountryInfo) map[string]string {
+	index := make(map[string]string, len(byCode))
+	for code, country := range byCode {
+		index[country.ISO3] = code
+	}
+	return index
+}
+
+// lookupCountry returns the dataset entry for an alpha-2 or alpha-3 code,
+// ignoring case
+func lookupCountry(code string) (*CountryInfo, bool) {
+	code = strings.ToUpper(code)
+	if alpha2, ok := countryCodesISO3[code]; ok {
+		code = alpha2
+	}
+	country, ok := countries[code]
+	return country, ok
+}
+
+// addCountryInfo fills in the country metadata fields of info from the
+// embedded dataset
+func addCountryInfo(info *IPInfo) {
+	country, ok := countries[info.CountryCode]
+	if !ok {
+		// Unknown to the dataset, e.g. MaxMind's "EU" or "AP" pseudo-codes
+		info.CountryCodeISO3 = info.CountryCode
+		return
+	}
+
+	info.CountryCodeISO3 = country.ISO3
+	info.CountryCodeNumeric = country.Numeric
+	info.CountryFlag = country.Flag
+	info.Capital = country.Capital
+	info.CurrencyCode = country.CurrencyCode
+	info.CurrencyName = country.CurrencyName
+	info.CallingCode = country.CallingCode
+	info.Languages = append([]string(nil), country.Languages...) // The dataset is shared
+	info.TLD = country.TLD
+	info.AreaKm2 = country.AreaKm2
+	info.Population = country.Population
+}
+
+// handleCountry serves GET /countries/{code}
+func handleCountry(w http.ResponseWriter, r *http.Request, code string) {
+	reqLogger := requestLogger(r)
+
+	country, ok := lookupCountry(code)
+	if !ok {
+		reqLogger.Debug("Unknown country code", "code", code)
+		http.Error(w, "Country not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(country); err != nil {
+		reqLogger.Error("Error encoding JSON response", "code", code, "error", err)
+	}
+}
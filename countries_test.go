@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCountryDataset(t *testing.T) {
+	if len(countries) < 249 {
+		t.Errorf("Expected at least 249 countries, got %d", len(countries))
+	}
+	for code, country := range countries {
+		if len(code) != 2 || len(country.ISO3) != 3 || len(country.Numeric) != 3 {
+			t.Errorf("Invalid codes for %s: %+v", code, country)
+		}
+		if country.Name == "" || country.Flag == "" || country.TLD == "" {
+			t.Errorf("Missing name, flag or TLD for %s", code)
+		}
+	}
+
+	// Lookups work by alpha-2 or alpha-3 code in any case
+	for _, code := range []string{"DE", "de", "DEU", "deu"} {
+		country, ok := lookupCountry(code)
+		if !ok || country.Code != "DE" {
+			t.Errorf("Expected lookup of %q to find DE, got %v", code, country)
+		}
+	}
+	if _, ok := lookupCountry("XX"); ok {
+		t.Error("Expected lookup of unknown code to fail")
+	}
+}
+
+func TestAddCountryInfo(t *testing.T) {
+	info := &IPInfo{CountryCode: "JP"}
+	addCountryInfo(info)
+	if info.CountryCodeISO3 != "JPN" || info.CountryCodeNumeric != "392" {
+		t.Errorf("Expected JPN/392, got %s/%s", info.CountryCodeISO3, info.CountryCodeNumeric)
+	}
+	if info.CurrencyCode != "JPY" || info.CallingCode != "+81" || info.Capital != "Tokyo" || info.TLD != ".jp" {
+		t.Errorf("Unexpected metadata: %+v", info)
+	}
+	if len(info.Languages) != 1 || info.Languages[0] != "ja" || info.CountryFlag != "🇯🇵" {
+		t.Errorf("Unexpected languages or flag: %v %s", info.Languages, info.CountryFlag)
+	}
+
+	// Codes unknown to the dataset fall back to the alpha-2 code
+	info = &IPInfo{CountryCode: "TS"}
+	addCountryInfo(info)
+	if info.CountryCodeISO3 != "TS" || info.CurrencyCode != "" {
+		t.Errorf("Expected fallback for unknown country, got %+v", info)
+	}
+}
+
+func TestHandleCountry(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+	config.Host = ""
+
+	w := httptest.NewRecorder()
+	handleRequest(w, httptest.NewRequest("GET", "/countries/gbr", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var country CountryInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &country); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if country.Code != "GB" || country.CurrencyCode != "GBP" || country.TLD != ".uk" {
+		t.Errorf("Unexpected country: %+v", country)
+	}
+
+	w = httptest.NewRecorder()
+	handleRequest(w, httptest.NewRequest("GET", "/countries/XX", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown country, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handleRequest(w, httptest.NewRequest("GET", "/countries/", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 without a code, got %d", w.Code)
+	}
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -38,7 +44,7 @@ func TestDownloadDatabase(t *testing.T) {
 	testFilePath := filepath.Join(tempDir, "test-db.mmdb")
 
 	// Test download
-	err = downloadDatabase(server.URL, testFilePath)
+	err = downloadDatabase(server.URL, testFilePath, nil)
 	if err != nil {
 		t.Fatalf("downloadDatabase failed: %v", err)
 	}
@@ -54,7 +60,7 @@ func TestDownloadDatabase(t *testing.T) {
 	}
 
 	// Test with non-existent server
-	err = downloadDatabase("http://nonexistent.example.com", testFilePath)
+	err = downloadDatabase("http://nonexistent.example.com", testFilePath, nil)
 	if err == nil {
 		t.Error("Expected error when downloading from non-existent server, got nil")
 	}
@@ -111,6 +117,7 @@ func TestInitDatabases(t *testing.T) {
 
 	// Test initDatabases
 	err = initDatabases()
+	waitForIndexes()
 	if err != nil {
 		t.Fatalf("initDatabases failed: %v", err)
 	}
@@ -175,13 +182,13 @@ func TestUpdateDatabasesIfNeeded(t *testing.T) {
 			url:        server.URL,
 			localPath:  testDbPath,
 			lastUpdate: time.Now().Add(-31 * 24 * time.Hour), // 31 days old
-			mutex:      originalDatabases["city"].mutex,      // Reuse mutex
 			reader:     &MockReader{},
 		},
 	}
 
 	// Test updateDatabasesIfNeeded
 	updateDatabasesIfNeeded()
+	waitForIndexes()
 
 	// Verify lastUpdate was updated
 	if time.Since(databases["test"].lastUpdate) > time.Minute {
@@ -225,4 +232,191 @@ func TestStartDatabaseUpdater(t *testing.T) {
 	// Clean up
 	originalTicker.Stop()
 	testTicker.Stop()
-}
\ No newline at end of file
+}
+func TestDownloadDatabaseHeadersAndArchive(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// Build a MaxMind style tarball containing a database file
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	content := []byte("MOCK_MMDB_FROM_ARCHIVE")
+	tw.WriteHeader(&tar.Header{Name: "GeoLite2-City_20250101/COPYRIGHT.txt", Mode: 0644, Size: 4, Typeflag: tar.TypeReg})
+	tw.Write([]byte("(c) "))
+	tw.WriteHeader(&tar.Header{Name: "GeoLite2-City_20250101/GeoLite2-City.mmdb", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+	tw.Write(content)
+	tw.Close()
+	gz.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Basic dGVzdDp0ZXN0" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write(archive.Bytes())
+	}))
+	defer server.Close()
+
+	testFilePath := filepath.Join(tempDir, "GeoLite2-City.mmdb")
+
+	// Without the header the download fails and leaves no partial file behind
+	if err := downloadDatabase(server.URL, testFilePath, nil); err == nil {
+		t.Fatal("Expected error without Authorization header, got nil")
+	}
+	if _, err := os.Stat(testFilePath); !os.IsNotExist(err) {
+		t.Errorf("Expected failed download to be removed, got %v", err)
+	}
+
+	headers := map[string]string{"Authorization": "Basic dGVzdDp0ZXN0"}
+	if err := downloadDatabase(server.URL, testFilePath, headers); err != nil {
+		t.Fatalf("downloadDatabase failed: %v", err)
+	}
+	data, err := os.ReadFile(testFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read downloaded file: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("Expected extracted database content, got %q", data)
+	}
+}
+
+func TestDatabaseSourcesUnmarshal(t *testing.T) {
+	cfg := defaultConfig
+	data := []byte(`{"databases": {
+		"asn": {"enabled": false},
+		"city": {"url": "https://download.maxmind.com/geoip/databases/{edition_id}/download?suffix=tar.gz",
+		         "edition_id": "GeoIP2-City", "file": "GeoIP2-City.mmdb"}
+	}}`)
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatalf("Failed to unmarshal databases: %v", err)
+	}
+
+	if cfg.Databases["asn"].Enabled {
+		t.Error("Expected asn database to be disabled")
+	}
+	if !cfg.Databases["country"].Enabled || cfg.Databases["country"].URL == "" {
+		t.Errorf("Expected country database to keep its defaults, got %+v", cfg.Databases["country"])
+	}
+
+	city := cfg.Databases["city"]
+	if !city.Enabled {
+		t.Error("Expected city database to stay enabled when only its source changes")
+	}
+	if city.downloadURL() != "https://download.maxmind.com/geoip/databases/GeoIP2-City/download?suffix=tar.gz" {
+		t.Errorf("Unexpected download URL: %s", city.downloadURL())
+	}
+
+	// The defaults themselves must not change
+	if !defaultDatabaseSources["asn"].Enabled || defaultDatabaseSources["city"].EditionID != "GeoLite2-City" {
+		t.Error("Loading a configuration modified the default database sources")
+	}
+
+	dbs := buildDatabases("/data", cfg.Databases)
+	if _, ok := dbs["asn"]; ok {
+		t.Error("Disabled database should not be in the registry")
+	}
+	if dbs["city"].localPath != filepath.Join("/data", "GeoIP2-City.mmdb") {
+		t.Errorf("Unexpected city path: %s", dbs["city"].localPath)
+	}
+}
+
+func TestGetIPInfoDatabaseSubsets(t *testing.T) {
+	originalDatabases := databases
+	defer func() { databases = originalDatabases }()
+
+	// Country only: city and ASN fields are left out
+	databases = map[string]*dbConfig{
+		"country": {reader: &MockReader{}},
+	}
+	info, err := getIPInfo(net.ParseIP("8.8.8.8"))
+	if err != nil {
+		t.Fatalf("getIPInfo failed with country database only: %v", err)
+	}
+	if info.CountryCode != "TS" {
+		t.Errorf("Expected country code 'TS', got '%s'", info.CountryCode)
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatalf("Failed to marshal IP info: %v", err)
+	}
+	var fields map[string]interface{}
+	json.Unmarshal(data, &fields)
+	for _, key := range []string{"asn", "org", "city", "latitude", "utc_offset"} {
+		if _, ok := fields[key]; ok {
+			t.Errorf("Expected %s to be omitted without its database", key)
+		}
+	}
+	for _, key := range []string{"ip", "network", "country_code", "in_eu"} {
+		if _, ok := fields[key]; !ok {
+			t.Errorf("Expected %s to be present", key)
+		}
+	}
+	if !strings.HasPrefix(string(data), `{"ip":"8.8.8.8","network"`) {
+		t.Errorf("Expected field order to be preserved, got %s", data)
+	}
+
+	// City only: the country comes from the city record
+	databases = map[string]*dbConfig{
+		"city": {reader: &MockReader{}},
+	}
+	info, err = getIPInfo(net.ParseIP("8.8.8.8"))
+	if err != nil {
+		t.Fatalf("getIPInfo failed with city database only: %v", err)
+	}
+	if info.City != "Test City" || info.CountryName != "Test Country" || info.ContinentCode != "TE" {
+		t.Errorf("Expected city and country from city database, got %+v", info)
+	}
+
+	// No databases at all
+	databases = map[string]*dbConfig{}
+	if _, err := getIPInfo(net.ParseIP("8.8.8.8")); err != nil {
+		t.Errorf("getIPInfo failed without databases: %v", err)
+	}
+}
+
+func TestRebuildStaleIndexes(t *testing.T) {
+	originalIndexes := databaseIndexes
+	originalDatabases := databases
+	defer func() {
+		databaseIndexes = originalIndexes
+		databases = originalDatabases
+	}()
+
+	builds := 0
+	databaseIndexes = map[string]*databaseIndex{
+		"asn": {build: func() { builds++ }, reads: asnIndexReads},
+	}
+
+	// A pass swapping every database it reads builds the index once
+	databases = map[string]*dbConfig{}
+	for _, name := range []string{"city", "asn", "country", "isp"} {
+		markIndexesStale(name)
+	}
+	if builds != 0 {
+		t.Errorf("Expected no build before the pass ends, got %d", builds)
+	}
+	rebuildStaleIndexes()
+	waitForIndexes()
+	rebuildStaleIndexes()
+	waitForIndexes()
+	if builds != 1 {
+		t.Errorf("Expected one build, got %d", builds)
+	}
+
+	// The country database is only read without a city database
+	databases = map[string]*dbConfig{"city": {reader: &MockReader{}}}
+	markIndexesStale("country")
+	rebuildStaleIndexes()
+	waitForIndexes()
+	if builds != 1 {
+		t.Errorf("Expected no build for the country database next to a city database, got %d", builds)
+	}
+	markIndexesStale("isp")
+	markIndexesStale("city")
+	rebuildStaleIndexes()
+	waitForIndexes()
+	if builds != 2 {
+		t.Errorf("Expected a build for the city database, got %d", builds)
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DatabaseSource describes where a MaxMind database comes from and where it
+// is stored locally
+type DatabaseSource struct {
+	Enabled   bool              `json:"enabled"`               // Whether the database is loaded at all
+	URL       string            `json:"url"`                   // Download URL; "{edition_id}" is replaced with EditionID
+	File      string            `json:"file"`                  // File name inside db_dir
+	EditionID string            `json:"edition_id"`            // MaxMind edition, e.g. GeoLite2-City
+	Headers   map[string]string `json:"headers" secret:"true"` // Extra request headers, e.g. Authorization
+	Optional  bool              `json:"optional"`              // Skip instead of failing when the file is missing and can't be downloaded
+}
+
+// DatabaseSources maps database names (asn, city, country) to their source
+type DatabaseSources map[string]DatabaseSource
+
+// UnmarshalJSON merges every configured database over the existing entry of
+// the same name, so that a configuration file only needs to list the
+// settings it changes. New entries are enabled unless stated otherwise.
+func (s *DatabaseSources) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	merged := DatabaseSources{}
+	for name, source := range *s {
+		merged[name] = source
+	}
+	for name, value := range raw {
+		source, ok := merged[name]
+		if !ok {
+			source = DatabaseSource{Enabled: true}
+		}
+		if err := json.Unmarshal(value, &source); err != nil {
+			// Keep type errors pointing at the field, e.g. city.url
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				typeErr.Field = strings.TrimSuffix(name+"."+typeErr.Field, ".")
+				return typeErr
+			}
+			return fmt.Errorf("database %s: %v", name, err)
+		}
+		merged[name] = source
+	}
+
+	*s = merged
+	return nil
+}
+
+// fileName returns the local file name of the database
+func (s DatabaseSource) fileName(name string) string {
+	if s.File != "" {
+		return s.File
+	}
+	if s.EditionID != "" {
+		return s.EditionID + ".mmdb"
+	}
+	return name + ".mmdb"
+}
+
+// downloadURL returns the URL with the edition ID filled in
+func (s DatabaseSource) downloadURL() string {
+	return strings.ReplaceAll(s.URL, "{edition_id}", s.EditionID)
+}
+
+// Names of the databases the service knows how to query
+var knownDatabases = []string{"asn", "city", "country", "anonymous_ip", "isp", "connection_type", "domain"}
+
+// Default database sources. These mirror the GeoLite2 databases and need no
+// MaxMind account.
+var defaultDatabaseSources = DatabaseSources{
+	"asn": {
+		Enabled:   true,
+		URL:       "https://github.com/P3TERX/GeoLite.mmdb/raw/download/{edition_id}.mmdb",
+		File:      "GeoLite2-ASN.mmdb",
+		EditionID: "GeoLite2-ASN",
+	},
+	"city": {
+		Enabled:   true,
+		URL:       "https://github.com/P3TERX/GeoLite.mmdb/raw/download/{edition_id}.mmdb",
+		File:      "GeoLite2-City.mmdb",
+		EditionID: "GeoLite2-City",
+	},
+	"country": {
+		Enabled:   true,
+		URL:       "https://github.com/P3TERX/GeoLite.mmdb/raw/download/{edition_id}.mmdb",
+		File:      "GeoLite2-Country.mmdb",
+		EditionID: "GeoLite2-Country",
+	},
+
+	// Commercial GeoIP2 databases, loaded when their file is present in
+	// db_dir or a licensed download URL is configured
+	"anonymous_ip": {
+		Enabled:   true,
+		Optional:  true,
+		File:      "GeoIP2-Anonymous-IP.mmdb",
+		EditionID: "GeoIP2-Anonymous-IP",
+	},
+	"isp": {
+		Enabled:   true,
+		Optional:  true,
+		File:      "GeoIP2-ISP.mmdb",
+		EditionID: "GeoIP2-ISP",
+	},
+	"connection_type": {
+		Enabled:   true,
+		Optional:  true,
+		File:      "GeoIP2-Connection-Type.mmdb",
+		EditionID: "GeoIP2-Connection-Type",
+	},
+	"domain": {
+		Enabled:   true,
+		Optional:  true,
+		File:      "GeoIP2-Domain.mmdb",
+		EditionID: "GeoIP2-Domain",
+	},
+}
+
+// buildDatabases creates the database registry for the enabled databases
+func buildDatabases(dir string, sources DatabaseSources) map[string]*dbConfig {
+	dbs := make(map[string]*dbConfig)
+	for name, source := range sources {
+		if !source.Enabled {
+			continue
+		}
+		dbs[name] = &dbConfig{
+			url:       source.downloadURL(),
+			localPath: filepath.Join(dir, source.fileName(name)),
+			headers:   source.Headers,
+			optional:  source.Optional,
+		}
+	}
+	return dbs
+}
+
+// configureDatabases replaces the database registry with the enabled
+// databases from cfg
+func configureDatabases(cfg Config) {
+	dbDir = cfg.DBDir
+	databases = buildDatabases(cfg.DBDir, cfg.Databases)
+}
+
+// databaseIndex is built by walking whole databases. Swapping a database it
+// reads marks it stale, and stale indexes are rebuilt once the databases of
+// an open, update or reload pass are all in place.
+type databaseIndex struct {
+	build    func()
+	reads    func(database string) bool
+	stale    bool
+	building bool
+}
+
+// Indexes built from whole databases, by name
+var databaseIndexes = map[string]*databaseIndex{
+	"asn":    {build: buildASNIndex, reads: asnIndexReads},
+	"search": {build: buildSearchIndex, reads: func(database string) bool { return database == "city" }},
+}
+
+var (
+	indexMutex  sync.Mutex     // Guards the stale and building flags
+	indexBuilds sync.WaitGroup // Running index builds
+)
+
+// markIndexesStale marks the indexes reading the named database for a
+// rebuild
+func markIndexesStale(name string) {
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+	for _, index := range databaseIndexes {
+		if index.reads(name) {
+			index.stale = true
+		}
+	}
+}
+
+// rebuildStaleIndexes rebuilds the stale indexes in the background. The
+// previous indexes are served until the new ones are ready, and an index
+// that goes stale while it is being built is built again afterwards.
+func rebuildStaleIndexes() {
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+	for _, index := range databaseIndexes {
+		if !index.stale || index.building {
+			continue
+		}
+		index.stale, index.building = false, true
+		indexBuilds.Add(1)
+		go func(index *databaseIndex) {
+			defer indexBuilds.Done()
+			index.build()
+
+			indexMutex.Lock()
+			index.building = false
+			again := index.stale
+			indexMutex.Unlock()
+			if again {
+				rebuildStaleIndexes()
+			}
+		}(index)
+	}
+}
+
+// waitForIndexes waits until no index is being built
+func waitForIndexes() {
+	indexBuilds.Wait()
+}
+
+// readDatabase calls fn with the reader of the named database while holding
+// its read lock. It reports false if the database is disabled or not loaded.
+func readDatabase(name string, fn func(Reader) error) (bool, error) {
+	db, ok := databases[name]
+	if !ok {
+		return false, nil
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	if db.reader == nil {
+		return false, nil
+	}
+	return true, fn(db.reader)
+}
+
+// checkDatabases validates the database section of the configuration
+func (v *configValidator) checkDatabases(sources DatabaseSources) {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	enabled := 0
+	for _, name := range names {
+		source := sources[name]
+		field := "databases." + name
+		if !isKnownDatabase(name) {
+			v.addf(field, "unknown database (expected one of %s)", strings.Join(knownDatabases, ", "))
+			continue
+		}
+		if !source.Enabled {
+			continue
+		}
+		if !source.Optional {
+			enabled++
+		}
+		if source.URL != "" {
+			v.url(field+".url", source.downloadURL())
+		}
+		if strings.ContainsAny(source.fileName(name), `/\`) {
+			v.addf(field+".file", "must be a file name, not a path")
+		}
+	}
+
+	if enabled == 0 {
+		v.addf("databases", "at least one database must be enabled, optional ones don't count")
+	}
+}
+
+// isKnownDatabase reports whether name is a database the service can query
+func isKnownDatabase(name string) bool {
+	for _, known := range knownDatabases {
+		if name == known {
+			return true
+		}
+	}
+	return false
+}
+
+// writeDatabase copies a downloaded database to out. MaxMind's own download
+// endpoint serves gzipped tarballs, in which case the .mmdb file inside is
+// extracted.
+func writeDatabase(out io.Writer, body io.Reader) error {
+	reader := bufio.NewReader(body)
+	magic, err := reader.Peek(2)
+	if err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		_, err = io.Copy(out, reader)
+		return err
+	}
+
+	gz, err := gzip.NewReader(reader)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return fmt.Errorf("no .mmdb file found in archive")
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeReg && strings.HasSuffix(header.Name, ".mmdb") {
+			_, err = io.Copy(out, archive)
+			return err
+		}
+	}
+}
+
+// newDownloadRequest creates a GET request carrying the configured headers
+func newDownloadRequest(url string, headers map[string]string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	return req, nil
+}
+
+// removeOnError deletes a partially written file if err is set
+func removeOnError(path string, err *error) {
+	if *err != nil {
+		os.Remove(path)
+	}
+}
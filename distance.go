@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+const (
+	earthRadiusKm = 6371.0088 // Mean Earth radius
+	kmPerMile     = 1.609344
+)
+
+// distancePoint is an end of a GET /distance measurement
+type distancePoint struct {
+	IP             string  `json:"ip,omitempty"`
+	Latitude       float64 `json:"latitude"`
+	Longitude      float64 `json:"longitude"`
+	AccuracyRadius uint16  `json:"accuracy_radius"` // In km; 0 for a given coordinate
+	City           string  `json:"city,omitempty"`
+	CountryCode    string  `json:"country_code,omitempty"`
+}
+
+// distanceResult is the response of GET /distance
+type distanceResult struct {
+	From          distancePoint `json:"from"`
+	To            distancePoint `json:"to"`
+	DistanceKm    float64       `json:"distance_km"`
+	DistanceMiles float64       `json:"distance_miles"`
+	Bearing       float64       `json:"bearing"`        // Initial bearing from "from" to "to" in degrees, clockwise from north
+	UncertaintyKm float64       `json:"uncertainty_km"` // Sum of both accuracy radii
+	Confidence    float64       `json:"confidence"`     // 0 to 1, how much of the distance isn't explained by the uncertainty
+}
+
+// greatCircle returns the haversine distance in km and the initial bearing
+// in degrees between two coordinates
+func greatCircle(lat1, lon1, lat2, lon2 float64) (float64, float64) {
+	phi1, phi2 := lat1*math.Pi/180, lat2*math.Pi/180
+	dPhi := phi2 - phi1
+	dLambda := (lon2 - lon1) * math.Pi / 180
+
+	a := math.Sin(dPhi/2)*math.Sin(dPhi/2) + math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLambda/2)*math.Sin(dLambda/2)
+	distance := 2 * earthRadiusKm * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+
+	y := math.Sin(dLambda) * math.Cos(phi2)
+	x := math.Cos(phi1)*math.Sin(phi2) - math.Sin(phi1)*math.Cos(phi2)*math.Cos(dLambda)
+	bearing := math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+	return distance, bearing
+}
+
+// distanceConfidence is 1 when the distance is much larger than the
+// combined accuracy radii and 0 when they could explain all of it
+func distanceConfidence(distance, uncertainty float64) float64 {
+	if uncertainty <= 0 {
+		return 1
+	}
+	return distance / (distance + uncertainty)
+}
+
+// round rounds to the given number of decimals
+func round(value float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(value*scale) / scale
+}
+
+// locateIP returns the city database location of an address
+func locateIP(address string) (distancePoint, int, error) {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return distancePoint{}, http.StatusBadRequest, fmt.Errorf("invalid IP address %q", address)
+	}
+	info, err := getIPInfo(ip)
+	if err != nil {
+		return distancePoint{}, http.StatusInternalServerError, fmt.Errorf("error getting IP info: %v", err)
+	}
+	if info.omit["latitude"] || (info.Latitude == 0 && info.Longitude == 0 && info.AccuracyRadius == 0) {
+		return distancePoint{}, http.StatusUnprocessableEntity, fmt.Errorf("no location for %s", address)
+	}
+	return distancePoint{
+		IP:             info.IP,
+		Latitude:       info.Latitude,
+		Longitude:      info.Longitude,
+		AccuracyRadius: info.AccuracyRadius,
+		City:           info.City,
+		CountryCode:    info.CountryCode,
+	}, http.StatusOK, nil
+}
+
+// parseCoordinate reads the lat and lon parameters
+func parseCoordinate(latValue, lonValue string) (distancePoint, error) {
+	lat, err := strconv.ParseFloat(latValue, 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return distancePoint{}, fmt.Errorf("invalid latitude %q", latValue)
+	}
+	lon, err := strconv.ParseFloat(lonValue, 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return distancePoint{}, fmt.Errorf("invalid longitude %q", lonValue)
+	}
+	return distancePoint{Latitude: lat, Longitude: lon}, nil
+}
+
+// handleDistance serves GET /distance?from={ip}&to={ip} and
+// GET /distance?from={ip}&lat={lat}&lon={lon}
+func handleDistance(w http.ResponseWriter, r *http.Request) {
+	reqLogger := requestLogger(r)
+	params := r.URL.Query()
+
+	if params.Get("from") == "" || (params.Get("to") == "") == (params.Get("lat") == "" && params.Get("lon") == "") {
+		http.Error(w, "Expected from and either to or lat and lon", http.StatusBadRequest)
+		return
+	}
+
+	from, status, err := locateIP(params.Get("from"))
+	if err != nil {
+		reqLogger.Debug("Distance lookup failed", "from", maskIP(params.Get("from")), "error", err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	var to distancePoint
+	if params.Get("to") != "" {
+		to, status, err = locateIP(params.Get("to"))
+	} else {
+		to, err = parseCoordinate(params.Get("lat"), params.Get("lon"))
+		status = http.StatusBadRequest
+	}
+	if err != nil {
+		reqLogger.Debug("Distance lookup failed", "to", maskIP(params.Get("to")), "error", err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	distance, bearing := greatCircle(from.Latitude, from.Longitude, to.Latitude, to.Longitude)
+	uncertainty := float64(from.AccuracyRadius) + float64(to.AccuracyRadius)
+	writeJSON(w, r, distanceResult{
+		From:          from,
+		To:            to,
+		DistanceKm:    round(distance, 1),
+		DistanceMiles: round(distance/kmPerMile, 1),
+		Bearing:       round(bearing, 1),
+		UncertaintyKm: uncertainty,
+		Confidence:    round(distanceConfidence(distance, uncertainty), 2),
+	})
+}
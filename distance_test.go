@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// placedReader returns MockReader data moved to a fixed coordinate per IP
+type placedReader struct {
+	MockReader
+	places map[string][3]float64 // Latitude, longitude and accuracy radius
+}
+
+func (r *placedReader) City(ip net.IP) (*geoip2.City, error) {
+	city, err := r.MockReader.City(ip)
+	if err != nil {
+		return nil, err
+	}
+	place := r.places[ip.String()]
+	city.Location.Latitude, city.Location.Longitude = place[0], place[1]
+	city.Location.AccuracyRadius = uint16(place[2])
+	return city, nil
+}
+
+func TestGreatCircle(t *testing.T) {
+	// London to Paris
+	distance, bearing := greatCircle(51.5074, -0.1278, 48.8566, 2.3522)
+	if math.Abs(distance-343.5) > 1 {
+		t.Errorf("Expected about 343.5 km, got %v", distance)
+	}
+	if math.Abs(bearing-148.1) > 0.5 {
+		t.Errorf("Expected bearing about 148.1, got %v", bearing)
+	}
+
+	if distance, _ := greatCircle(10, 20, 10, 20); distance != 0 {
+		t.Errorf("Expected zero distance, got %v", distance)
+	}
+}
+
+func TestHandleDistance(t *testing.T) {
+	originalDatabases := databases
+	originalConfig := config
+	defer func() {
+		databases = originalDatabases
+		config = originalConfig
+	}()
+
+	config = defaultConfig
+	databases = map[string]*dbConfig{"city": {reader: &placedReader{places: map[string][3]float64{
+		"81.2.69.142":   {51.5074, -0.1278, 20},
+		"2.160.0.1":     {48.8566, 2.3522, 100},
+		"81.2.69.160":   {51.5080, -0.1280, 50},
+		"203.0.113.200": {0, 0, 0},
+	}}}}
+
+	get := func(query string) (int, distanceResult) {
+		w := httptest.NewRecorder()
+		handleRequest(w, httptest.NewRequest("GET", "/distance?"+query, nil))
+		var result distanceResult
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+		}
+		return w.Code, result
+	}
+
+	status, result := get("from=81.2.69.142&to=2.160.0.1")
+	if status != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", status)
+	}
+	if math.Abs(result.DistanceKm-343.5) > 1 || math.Abs(result.DistanceMiles-result.DistanceKm/kmPerMile) > 0.1 {
+		t.Errorf("Unexpected distance %+v", result)
+	}
+	if result.UncertaintyKm != 120 || result.Confidence != 0.74 {
+		t.Errorf("Expected uncertainty 120 and confidence 0.74, got %v and %v", result.UncertaintyKm, result.Confidence)
+	}
+	if result.From.IP != "81.2.69.142" || result.To.IP != "2.160.0.1" || result.From.CountryCode == "" {
+		t.Errorf("Expected both addresses in the response, got %+v", result)
+	}
+
+	// Nearby addresses are within each other's accuracy radius
+	_, result = get("from=81.2.69.142&to=81.2.69.160")
+	if result.Confidence > 0.01 {
+		t.Errorf("Expected low confidence for nearby addresses, got %v", result.Confidence)
+	}
+
+	status, result = get("from=81.2.69.142&lat=48.8566&lon=2.3522")
+	if status != http.StatusOK || math.Abs(result.DistanceKm-343.5) > 1 || result.UncertaintyKm != 20 || result.To.IP != "" {
+		t.Errorf("Unexpected distance to coordinate: %d %+v", status, result)
+	}
+
+	for query, expected := range map[string]int{
+		"from=81.2.69.142":                    http.StatusBadRequest,
+		"to=81.2.69.142":                      http.StatusBadRequest,
+		"from=81.2.69.142&to=2.160.0.1&lat=1": http.StatusBadRequest,
+		"from=invalid&to=2.160.0.1":           http.StatusBadRequest,
+		"from=81.2.69.142&lat=91&lon=0":       http.StatusBadRequest,
+		"from=81.2.69.142&lat=10":             http.StatusBadRequest,
+		"from=10.0.0.1&to=2.160.0.1":          http.StatusUnprocessableEntity,
+		"from=203.0.113.200&lat=0&lon=0":      http.StatusUnprocessableEntity,
+	} {
+		if status, _ := get(query); status != expected {
+			t.Errorf("Expected %d for %s, got %d", expected, query, status)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNSConfig configures the DNS lookups used for reverse DNS and hostname
+// lookups
+type DNSConfig struct {
+	Resolver      string   `json:"resolver"`       // DNS server as host:port; empty uses the system resolver
+	Timeout       Duration `json:"timeout"`        // Time limit for a lookup, including forward confirmation
+	ReverseLookup bool     `json:"reverse_lookup"` // Add the hostname to every response, not only with ?rdns=1
+	CacheTTL      Duration `json:"cache_ttl"`      // How long reverse lookup results are cached
+	MaxAddresses  int      `json:"max_addresses"`  // Addresses of a hostname that are looked up
+}
+
+var defaultDNSConfig = DNSConfig{
+	Timeout:      Duration(time.Second),
+	CacheTTL:     Duration(10 * time.Minute),
+	MaxAddresses: 16,
+}
+
+// dnsResolver is the subset of *net.Resolver used for lookups, replaced in
+// tests
+type dnsResolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+var (
+	dnsCfg                        = defaultDNSConfig
+	resolver          dnsResolver = net.DefaultResolver
+	reverseCache                  = map[string]reverseCacheEntry{}
+	reverseCacheMutex sync.Mutex
+)
+
+// Entries in the reverse lookup cache before expired ones are swept
+const reverseCacheSweepSize = 10000
+
+type reverseCacheEntry struct {
+	hostname string
+	expires  time.Time
+}
+
+// setupDNS applies the DNS configuration
+func setupDNS(cfg DNSConfig) {
+	dnsCfg = cfg
+	resolver = newResolver(cfg.Resolver)
+
+	reverseCacheMutex.Lock()
+	reverseCache = map[string]reverseCacheEntry{}
+	reverseCacheMutex.Unlock()
+}
+
+// newResolver returns a resolver sending every query to address, or the
+// system resolver if address is empty
+func newResolver(address string) *net.Resolver {
+	if address == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, address)
+		},
+	}
+}
+
+// wantReverseDNS reports whether a request asks for the hostname, either
+// with ?rdns= or through the dns.reverse_lookup setting
+func wantReverseDNS(r *http.Request) bool {
+	if value := r.URL.Query().Get("rdns"); value != "" {
+		enabled, _ := strconv.ParseBool(value)
+		return enabled
+	}
+	return dnsCfg.ReverseLookup
+}
+
+// addHostname fills in the forward-confirmed hostname of ip. The field is
+// present but empty if there is none.
+func addHostname(info *IPInfo, ip net.IP) {
+	info.Hostname = reverseLookup(ip)
+	delete(info.omit, "hostname")
+}
+
+// reverseLookup returns the first PTR name of ip that resolves back to ip,
+// or "" if there is none or the lookup fails. Results are cached for
+// dns.cache_ttl.
+func reverseLookup(ip net.IP) string {
+	key := ip.String()
+	now := timeNow()
+
+	reverseCacheMutex.Lock()
+	entry, ok := reverseCache[key]
+	reverseCacheMutex.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.hostname
+	}
+
+	ctx, cancel := dnsContext()
+	defer cancel()
+
+	hostname, err := confirmedHostname(ctx, ip)
+	if err != nil {
+		logger.Debug("Reverse DNS lookup failed", "ip", maskIP(key), "error", err)
+		// Don't cache timeouts and server failures, only answers
+		if !isNotFound(err) {
+			return ""
+		}
+	}
+
+	reverseCacheMutex.Lock()
+	if len(reverseCache) >= reverseCacheSweepSize {
+		for k, e := range reverseCache {
+			if !now.Before(e.expires) {
+				delete(reverseCache, k)
+			}
+		}
+	}
+	reverseCache[key] = reverseCacheEntry{hostname: hostname, expires: now.Add(time.Duration(dnsCfg.CacheTTL))}
+	reverseCacheMutex.Unlock()
+
+	return hostname
+}
+
+// dnsContext limits a lookup to dns.timeout
+func dnsContext() (context.Context, context.CancelFunc) {
+	timeout := time.Duration(dnsCfg.Timeout)
+	if timeout <= 0 {
+		timeout = time.Duration(defaultDNSConfig.Timeout)
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// confirmedHostname looks up the PTR names of ip and returns the first one
+// whose A or AAAA records include ip
+func confirmedHostname(ctx context.Context, ip net.IP) (string, error) {
+	names, err := resolver.LookupAddr(ctx, ip.String())
+	if err != nil {
+		return "", err
+	}
+
+	for _, name := range names {
+		addrs, err := resolver.LookupIPAddr(ctx, name)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			continue
+		}
+		for _, addr := range addrs {
+			if addr.IP.Equal(ip) {
+				return strings.TrimSuffix(name, "."), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// isHostname reports whether s looks like a fully qualified domain name
+// rather than a mistyped IP address
+func isHostname(s string) bool {
+	s = strings.TrimSuffix(s, ".")
+	if len(s) > 253 {
+		return false
+	}
+	labels := strings.Split(s, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	// Top-level domains are never numeric, so "1.2.3" is a bad address
+	_, err := strconv.Atoi(labels[len(labels)-1])
+	return err != nil
+}
+
+// hostnameResult is the response to a hostname lookup
+type hostnameResult struct {
+	Hostname  string    `json:"hostname"`
+	Addresses []*IPInfo `json:"addresses"`
+	Truncated bool      `json:"truncated,omitempty"` // More than dns.max_addresses addresses were found
+	Error     *dnsError `json:"error,omitempty"`
+}
+
+// dnsError describes a failed hostname resolution
+type dnsError struct {
+	Code    string `json:"code"` // "not_found", "timeout" or "failure"
+	Message string `json:"message"`
+}
+
+// resolveHostname returns the unique A and AAAA addresses of host, at most
+// dns.max_addresses of them, and whether there were more
+func resolveHostname(host string) ([]net.IP, bool, error) {
+	ctx, cancel := dnsContext()
+	defer cancel()
+
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, false, err
+	}
+
+	limit := dnsCfg.MaxAddresses
+	if limit <= 0 {
+		limit = defaultDNSConfig.MaxAddresses
+	}
+	var ips []net.IP
+	seen := map[string]bool{}
+	for _, addr := range addrs {
+		key := addr.IP.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if len(ips) == limit {
+			return ips, true, nil
+		}
+		ips = append(ips, addr.IP)
+	}
+	if len(ips) == 0 {
+		return nil, false, &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}
+	}
+	return ips, false, nil
+}
+
+// handleHostnameLookup serves /ipgeo/{hostname} with the information for
+// every address of the hostname. DNS failures are reported in the error
+// field of the response.
+func handleHostnameLookup(w http.ResponseWriter, r *http.Request, host string) {
+	reqLogger := requestLogger(r)
+	result := hostnameResult{Hostname: strings.TrimSuffix(host, "."), Addresses: []*IPInfo{}}
+
+	ips, truncated, err := resolveHostname(host)
+	if err != nil {
+		reqLogger.Debug("Hostname lookup failed", "hostname", host, "error", err)
+		result.Error = &dnsError{Code: "failure", Message: err.Error()}
+		status := http.StatusBadGateway
+		if isNotFound(err) {
+			result.Error.Code, status = "not_found", http.StatusNotFound
+		} else if dnsErr, ok := err.(*net.DNSError); (ok && dnsErr.IsTimeout) || errors.Is(err, context.DeadlineExceeded) {
+			result.Error.Code, status = "timeout", http.StatusGatewayTimeout
+		}
+		writeJSONStatus(w, r, status, result)
+		return
+	}
+	result.Truncated = truncated
+
+	rdns := wantReverseDNS(r)
+	for _, ip := range ips {
+		info, err := getIPInfo(ip)
+		if err != nil {
+			reqLogger.Error("Error getting IP info", "hostname", host, "ip", maskIP(ip.String()), "error", err)
+			http.Error(w, fmt.Sprintf("Error getting IP info: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if rdns {
+			addHostname(info, ip)
+		}
+		result.Addresses = append(result.Addresses, info)
+	}
+
+	reqLogger.Debug("Successfully processed hostname", "hostname", host, "addresses", len(result.Addresses))
+	writeJSON(w, r, result)
+}
+
+// isNotFound reports whether err means the name has no records, as opposed
+// to the lookup failing
+func isNotFound(err error) bool {
+	dnsErr, ok := err.(*net.DNSError)
+	return ok && dnsErr.IsNotFound
+}
+
+// checkDNS validates the dns section
+func (v *configValidator) checkDNS(cfg DNSConfig) {
+	if cfg.Resolver != "" {
+		if _, port, err := net.SplitHostPort(cfg.Resolver); err != nil {
+			v.addf("dns.resolver", "%q must be host:port", cfg.Resolver)
+		} else {
+			v.port("dns.resolver", port)
+		}
+	}
+	v.positive("dns.timeout", cfg.Timeout)
+	if cfg.CacheTTL < 0 {
+		v.addf("dns.cache_ttl", "must not be negative")
+	}
+	if cfg.MaxAddresses < 1 {
+		v.addf("dns.max_addresses", "must be at least 1")
+	}
+}
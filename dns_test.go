@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// DNS record types served by stubDNSServer
+const (
+	dnsTypeA    = 1
+	dnsTypePTR  = 12
+	dnsTypeAAAA = 28
+)
+
+// stubDNSServer answers A, AAAA and PTR queries from a fixed set of records
+type stubDNSServer struct {
+	conn    net.PacketConn
+	records map[string][]string // "name type" to values, names fully qualified
+	queries int32
+}
+
+func newStubDNSServer(t *testing.T, records map[string][]string) *stubDNSServer {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start stub DNS server: %v", err)
+	}
+	server := &stubDNSServer{conn: conn, records: records}
+	go server.serve()
+	t.Cleanup(func() { conn.Close() })
+	return server
+}
+
+func (s *stubDNSServer) addr() string {
+	return s.conn.LocalAddr().String()
+}
+
+func (s *stubDNSServer) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		atomic.AddInt32(&s.queries, 1)
+		if response := s.answer(buf[:n]); response != nil {
+			s.conn.WriteTo(response, addr)
+		}
+	}
+}
+
+// answer builds the response to a query with a single question
+func (s *stubDNSServer) answer(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+
+	// Read the question name
+	var labels []string
+	offset := 12
+	for offset < len(query) && query[offset] != 0 {
+		length := int(query[offset])
+		labels = append(labels, string(query[offset+1:offset+1+length]))
+		offset += length + 1
+	}
+	questionEnd := offset + 5
+	if questionEnd > len(query) {
+		return nil
+	}
+	name := strings.ToLower(strings.Join(labels, ".")) + "."
+	qtype := binary.BigEndian.Uint16(query[offset+1:])
+
+	typeNames := map[uint16]string{dnsTypeA: "A", dnsTypeAAAA: "AAAA", dnsTypePTR: "PTR"}
+	values := s.records[name+" "+typeNames[qtype]]
+
+	response := append([]byte{}, query[:questionEnd]...)
+	flags := uint16(0x8180) // Response, recursion desired and available
+	if len(values) == 0 && !s.hasName(name) {
+		flags |= 3 // NXDOMAIN
+	}
+	binary.BigEndian.PutUint16(response[2:], flags)
+	binary.BigEndian.PutUint16(response[6:], uint16(len(values))) // Answers
+	binary.BigEndian.PutUint16(response[8:], 0)                   // Authority
+	binary.BigEndian.PutUint16(response[10:], 0)                  // Additional
+
+	for _, value := range values {
+		var rdata []byte
+		switch qtype {
+		case dnsTypeA:
+			rdata = net.ParseIP(value).To4()
+		case dnsTypeAAAA:
+			rdata = net.ParseIP(value).To16()
+		case dnsTypePTR:
+			for _, label := range strings.Split(strings.TrimSuffix(value, "."), ".") {
+				rdata = append(rdata, byte(len(label)))
+				rdata = append(rdata, label...)
+			}
+			rdata = append(rdata, 0)
+		}
+		response = append(response, 0xc0, 12) // Pointer to the question name
+		response = binary.BigEndian.AppendUint16(response, qtype)
+		response = binary.BigEndian.AppendUint16(response, 1) // IN
+		response = binary.BigEndian.AppendUint32(response, 60)
+		response = binary.BigEndian.AppendUint16(response, uint16(len(rdata)))
+		response = append(response, rdata...)
+	}
+	return response
+}
+
+func (s *stubDNSServer) hasName(name string) bool {
+	for key := range s.records {
+		if strings.HasPrefix(key, name+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestReverseLookup(t *testing.T) {
+	originalDNS := dnsCfg
+	originalNow := timeNow
+	defer func() {
+		setupDNS(originalDNS)
+		timeNow = originalNow
+	}()
+
+	server := newStubDNSServer(t, map[string][]string{
+		"10.2.0.192.in-addr.arpa. PTR": {"host.example.com."},
+		"host.example.com. A":          {"192.0.2.10"},
+		"11.2.0.192.in-addr.arpa. PTR": {"spoofed.example.com."},
+		"spoofed.example.com. A":       {"192.0.2.99"},
+	})
+	setupDNS(DNSConfig{Resolver: server.addr(), Timeout: Duration(2 * time.Second), CacheTTL: Duration(time.Minute)})
+
+	if hostname := reverseLookup(net.ParseIP("192.0.2.10")); hostname != "host.example.com" {
+		t.Errorf("Expected forward-confirmed hostname, got %q", hostname)
+	}
+	if hostname := reverseLookup(net.ParseIP("192.0.2.11")); hostname != "" {
+		t.Errorf("Expected no hostname when the name doesn't resolve back, got %q", hostname)
+	}
+	if hostname := reverseLookup(net.ParseIP("192.0.2.12")); hostname != "" {
+		t.Errorf("Expected no hostname without a PTR record, got %q", hostname)
+	}
+
+	// Answers are cached, including missing ones, until the TTL expires
+	queries := atomic.LoadInt32(&server.queries)
+	reverseLookup(net.ParseIP("192.0.2.10"))
+	reverseLookup(net.ParseIP("192.0.2.12"))
+	if atomic.LoadInt32(&server.queries) != queries {
+		t.Error("Expected cached results to be used")
+	}
+
+	timeNow = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	reverseLookup(net.ParseIP("192.0.2.10"))
+	if atomic.LoadInt32(&server.queries) == queries {
+		t.Error("Expected expired result to be looked up again")
+	}
+}
+
+// blockingResolver never answers, to test timeouts
+type blockingResolver struct{}
+
+func (blockingResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (blockingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestReverseLookupTimeout(t *testing.T) {
+	originalDNS := dnsCfg
+	defer setupDNS(originalDNS)
+
+	setupDNS(DNSConfig{Timeout: Duration(50 * time.Millisecond), CacheTTL: Duration(time.Minute)})
+	resolver = blockingResolver{}
+
+	start := time.Now()
+	if hostname := reverseLookup(net.ParseIP("192.0.2.20")); hostname != "" {
+		t.Errorf("Expected no hostname on timeout, got %q", hostname)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected lookup to give up after the timeout, took %v", elapsed)
+	}
+
+	// Timeouts aren't cached
+	reverseCacheMutex.Lock()
+	_, cached := reverseCache["192.0.2.20"]
+	reverseCacheMutex.Unlock()
+	if cached {
+		t.Error("Expected timeout not to be cached")
+	}
+}
+
+func TestHandleIPLookupReverseDNS(t *testing.T) {
+	originalDNS := dnsCfg
+	originalDatabases := databases
+	defer func() {
+		setupDNS(originalDNS)
+		databases = originalDatabases
+	}()
+
+	var mutex sync.Mutex
+	var lookups []string
+	resolverFunc := &fakeResolver{
+		addrs: map[string][]string{"81.2.69.142": {"mail.example.net."}},
+		ips:   map[string][]net.IPAddr{"mail.example.net.": {{IP: net.ParseIP("81.2.69.142")}}},
+		onLookup: func(name string) {
+			mutex.Lock()
+			lookups = append(lookups, name)
+			mutex.Unlock()
+		},
+	}
+	setupDNS(defaultDNSConfig)
+	resolver = resolverFunc
+	databases = map[string]*dbConfig{"asn": {reader: &MockReader{}}}
+
+	// Off by default
+	w := httptest.NewRecorder()
+	handleIPLookup(w, httptest.NewRequest("GET", "/ipgeo/81.2.69.142", nil), "81.2.69.142")
+	if strings.Contains(w.Body.String(), `"hostname"`) || len(lookups) != 0 {
+		t.Errorf("Expected no reverse lookup without ?rdns=1, got %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handleIPLookup(w, httptest.NewRequest("GET", "/ipgeo/81.2.69.142?rdns=1", nil), "81.2.69.142")
+	if !strings.Contains(w.Body.String(), `"hostname":"mail.example.net"`) {
+		t.Errorf("Expected hostname in response, got %s", w.Body.String())
+	}
+
+	// Enabled in the configuration, but can be turned off per request
+	setupDNS(DNSConfig{ReverseLookup: true, Timeout: defaultDNSConfig.Timeout})
+	resolver = resolverFunc
+	w = httptest.NewRecorder()
+	handleIPLookup(w, httptest.NewRequest("GET", "/ipgeo/81.2.69.142?rdns=0", nil), "81.2.69.142")
+	if strings.Contains(w.Body.String(), `"hostname"`) {
+		t.Errorf("Expected ?rdns=0 to disable the lookup, got %s", w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	handleIPLookup(w, httptest.NewRequest("GET", "/ipgeo/81.2.69.142", nil), "81.2.69.142")
+	if !strings.Contains(w.Body.String(), `"hostname":"mail.example.net"`) {
+		t.Errorf("Expected hostname with reverse_lookup enabled, got %s", w.Body.String())
+	}
+}
+
+// fakeResolver answers lookups from maps
+type fakeResolver struct {
+	addrs    map[string][]string
+	ips      map[string][]net.IPAddr
+	errs     map[string]error
+	onLookup func(name string)
+}
+
+func (f *fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	if f.onLookup != nil {
+		f.onLookup(addr)
+	}
+	if err := f.errs[addr]; err != nil {
+		return nil, err
+	}
+	if names, ok := f.addrs[addr]; ok {
+		return names, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+}
+
+func (f *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if f.onLookup != nil {
+		f.onLookup(host)
+	}
+	if err := f.errs[host]; err != nil {
+		return nil, err
+	}
+	if ips, ok := f.ips[host]; ok {
+		return ips, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestValidateDNS(t *testing.T) {
+	cfg := defaultConfig
+	cfg.DNS = DNSConfig{Resolver: "8.8.8.8", Timeout: 0, CacheTTL: -1}
+	err := validateConfig(cfg)
+	for _, expected := range []string{"dns.resolver", "dns.timeout", "dns.cache_ttl"} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected problem %q, got %v", expected, err)
+		}
+	}
+}
+
+func TestIsHostname(t *testing.T) {
+	tests := map[string]bool{
+		"example.com":        true,
+		"www.example.co.uk":  true,
+		"example.com.":       true,
+		"_dmarc.example.org": true,
+		"localhost":          false,
+		"not-an-ip-at-all":   false,
+		"192.168.1":          false,
+		"-bad.example.com":   false,
+		"bad..example.com":   false,
+		"bad host.com":       false,
+	}
+	for input, expected := range tests {
+		if got := isHostname(input); got != expected {
+			t.Errorf("isHostname(%q) = %v, expected %v", input, got, expected)
+		}
+	}
+}
+
+func TestHandleHostnameLookup(t *testing.T) {
+	originalDNS := dnsCfg
+	originalDatabases := databases
+	originalConfig := config
+	defer func() {
+		setupDNS(originalDNS)
+		databases = originalDatabases
+		config = originalConfig
+	}()
+
+	config = defaultConfig
+
+	cfg := defaultDNSConfig
+	cfg.MaxAddresses = 2
+	setupDNS(cfg)
+	resolver = &fakeResolver{
+		ips: map[string][]net.IPAddr{
+			"example.com": {
+				{IP: net.ParseIP("81.2.69.142")},
+				{IP: net.ParseIP("81.2.69.142")},
+				{IP: net.ParseIP("2001:db8::1")},
+			},
+			"big.example.com": {
+				{IP: net.ParseIP("81.2.69.142")},
+				{IP: net.ParseIP("81.2.69.143")},
+				{IP: net.ParseIP("81.2.69.144")},
+			},
+		},
+		errs: map[string]error{"broken.example.com": &net.DNSError{Err: "server misbehaving", Name: "broken.example.com"}},
+	}
+	databases = map[string]*dbConfig{"asn": {reader: &MockReader{}}}
+
+	lookup := func(host string) (int, hostnameResult) {
+		w := httptest.NewRecorder()
+		handleRequest(w, httptest.NewRequest("GET", "/ipgeo/"+host, nil))
+		var result hostnameResult
+		if w.Code != http.StatusInternalServerError {
+			if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+				t.Fatalf("Failed to decode response for %s: %v", host, err)
+			}
+		}
+		return w.Code, result
+	}
+
+	// Duplicate addresses are removed
+	status, result := lookup("example.com")
+	if status != http.StatusOK || result.Error != nil || result.Truncated {
+		t.Fatalf("Expected successful lookup, got %d %+v", status, result)
+	}
+	if len(result.Addresses) != 2 || result.Addresses[0].IP != "81.2.69.142" || result.Addresses[1].IP != "2001:db8::1" {
+		t.Errorf("Expected both unique addresses, got %+v", result.Addresses)
+	}
+
+	status, result = lookup("big.example.com")
+	if status != http.StatusOK || !result.Truncated || len(result.Addresses) != 2 {
+		t.Errorf("Expected addresses capped at max_addresses, got %d %+v", status, result)
+	}
+
+	status, result = lookup("missing.example.com")
+	if status != http.StatusNotFound || result.Error == nil || result.Error.Code != "not_found" {
+		t.Errorf("Expected not_found error, got %d %+v", status, result)
+	}
+	if result.Addresses == nil {
+		t.Error("Expected empty addresses array on error")
+	}
+
+	status, result = lookup("broken.example.com")
+	if status != http.StatusBadGateway || result.Error == nil || result.Error.Code != "failure" {
+		t.Errorf("Expected failure error, got %d %+v", status, result)
+	}
+
+	setupDNS(DNSConfig{Timeout: Duration(50 * time.Millisecond), MaxAddresses: 2})
+	resolver = blockingResolver{}
+	status, result = lookup("slow.example.com")
+	if status != http.StatusGatewayTimeout || result.Error == nil || result.Error.Code != "timeout" {
+		t.Errorf("Expected timeout error, got %d %+v", status, result)
+	}
+
+	// Client IP lookups and malformed addresses are never resolved
+	w := httptest.NewRecorder()
+	handleRequest(w, httptest.NewRequest("GET", "/ipgeo/192.168.1", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected malformed IP to be rejected, got %d", w.Code)
+	}
+}
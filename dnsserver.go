@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DNSServerConfig configures the DNS listener answering geo TXT queries
+type DNSServerConfig struct {
+	Enabled bool     `json:"enabled"`
+	Port    string   `json:"port"` // UDP and TCP
+	Zone    string   `json:"zone"` // Suffix of the names answered
+	TTL     Duration `json:"ttl"`  // TTL of the TXT records
+}
+
+var defaultDNSServerConfig = DNSServerConfig{
+	Port: "8053",
+	Zone: "geo.local",
+	TTL:  Duration(time.Hour),
+}
+
+// Labels under the zone selecting how the address is written
+const (
+	dnsLabelOrigin  = "origin"  // Reversed IPv4 octets, as Team Cymru: 4.4.8.8.origin.geo.local
+	dnsLabelOrigin6 = "origin6" // Reversed IPv6 nibbles, as ip6.arpa: ...8.b.d.0.1.0.0.2.origin6.geo.local
+	dnsLabelIP      = "ip"      // IPv4 in the usual order: 8.8.4.4.ip.geo.local
+)
+
+// parseGeoQueryName returns the address a query name asks about. inZone is
+// false for names outside the zone and exists is false for names in it that
+// can't have records; the zone and the form labels exist without an address.
+func parseGeoQueryName(name, zone string) (ip net.IP, inZone, exists bool) {
+	name = strings.ToLower(dns.Fqdn(name))
+	zone = strings.ToLower(dns.Fqdn(zone))
+	if name == zone {
+		return nil, true, true
+	}
+	if !strings.HasSuffix(name, "."+zone) {
+		return nil, false, false
+	}
+	labels := dns.SplitDomainName(strings.TrimSuffix(name, "."+zone))
+	form, labels := labels[len(labels)-1], labels[:len(labels)-1]
+	if form != dnsLabelOrigin && form != dnsLabelOrigin6 && form != dnsLabelIP {
+		return nil, true, false
+	}
+	if len(labels) == 0 {
+		return nil, true, true
+	}
+
+	if form == dnsLabelOrigin6 {
+		if len(labels) != 32 {
+			return nil, true, false
+		}
+		var hex strings.Builder
+		for i := 31; i >= 0; i-- {
+			if len(labels[i]) != 1 {
+				return nil, true, false
+			}
+			hex.WriteString(labels[i])
+			if i%4 == 0 && i > 0 {
+				hex.WriteByte(':')
+			}
+		}
+		ip = net.ParseIP(hex.String())
+	} else if len(labels) == 4 {
+		if form == dnsLabelOrigin {
+			labels = []string{labels[3], labels[2], labels[1], labels[0]}
+		}
+		ip = net.ParseIP(strings.Join(labels, ".")).To4()
+	}
+	return ip, true, ip != nil
+}
+
+// geoTXT formats an address in the Team Cymru origin layout, with the
+// organization in place of the registry: "15169 | 8.8.8.0/24 | US | Google LLC"
+func geoTXT(info *IPInfo) string {
+	return strings.Join([]string{
+		strings.TrimPrefix(info.ASN, "AS"),
+		info.Network,
+		info.CountryCode,
+		info.Org,
+	}, " | ")
+}
+
+// handleDNSQuery answers TXT queries for addresses in the zone. Names
+// outside the zone are refused; other names and types in the zone get an
+// empty answer or NXDOMAIN.
+func handleDNSQuery(w dns.ResponseWriter, req *dns.Msg) {
+	response := new(dns.Msg)
+	response.SetReply(req)
+	defer func() {
+		if err := w.WriteMsg(response); err != nil {
+			logger.Debug("Failed to write DNS response", "error", err)
+		}
+	}()
+
+	if req.Opcode != dns.OpcodeQuery || len(req.Question) != 1 {
+		response.Rcode = dns.RcodeFormatError
+		return
+	}
+	question := req.Question[0]
+	ip, inZone, exists := parseGeoQueryName(question.Name, config.DNSServer.Zone)
+	if !inZone {
+		response.Rcode = dns.RcodeRefused
+		return
+	}
+	response.Authoritative = true
+	if !exists {
+		response.Rcode = dns.RcodeNameError
+		return
+	}
+	if ip == nil || (question.Qtype != dns.TypeTXT && question.Qtype != dns.TypeANY) {
+		return
+	}
+
+	info, err := getIPInfo(ip)
+	if err != nil {
+		logger.Error("Error getting IP info", "ip", maskIP(ip.String()), "error", err)
+		response.Rcode = dns.RcodeServerFailure
+		return
+	}
+	response.Answer = append(response.Answer, &dns.TXT{
+		Hdr: dns.RR_Header{
+			Name:   question.Name,
+			Rrtype: dns.TypeTXT,
+			Class:  dns.ClassINET,
+			Ttl:    uint32(time.Duration(config.DNSServer.TTL) / time.Second),
+		},
+		Txt: []string{geoTXT(info)},
+	})
+	logger.Debug("Answered DNS query", "ip", maskIP(ip.String()), "country", info.CountryCode)
+}
+
+// newDNSServers returns a UDP and a TCP server answering geo queries
+func newDNSServers(packetConn net.PacketConn, listener net.Listener) []*dns.Server {
+	handler := dns.HandlerFunc(handleDNSQuery)
+	return []*dns.Server{
+		{PacketConn: packetConn, Handler: handler},
+		{Listener: listener, Handler: handler},
+	}
+}
+
+// startDNSServer listens on the DNS port over UDP and TCP and serves in the
+// background
+func startDNSServer() error {
+	addr := net.JoinHostPort(config.Host, config.DNSServer.Port)
+	packetConn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		packetConn.Close()
+		return err
+	}
+	logger.Info("Starting DNS server", "addr", addr, "zone", config.DNSServer.Zone)
+	for _, server := range newDNSServers(packetConn, listener) {
+		go func(server *dns.Server) {
+			fatal("DNS server stopped", "error", server.ActivateAndServe())
+		}(server)
+	}
+	return nil
+}
+
+// checkDNSServer validates the dns_server section
+func (v *configValidator) checkDNSServer(cfg Config) {
+	server := cfg.DNSServer
+	if !server.Enabled {
+		return
+	}
+	v.port("dns_server.port", server.Port)
+	if server.Port == cfg.Port || (cfg.ExtAuthz.Enabled && server.Port == cfg.ExtAuthz.Port) {
+		v.addf("dns_server.port", "must differ from the HTTP and ext_authz ports")
+	}
+	if _, ok := dns.IsDomainName(server.Zone); !ok || server.Zone == "" {
+		v.addf("dns_server.zone", "%q is not a domain name", server.Zone)
+	}
+	if server.TTL < 0 || time.Duration(server.TTL)/time.Second > 1<<31-1 {
+		v.addf("dns_server.ttl", "must be between 0 and 2^31-1 seconds")
+	}
+}
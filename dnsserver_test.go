@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseGeoQueryName(t *testing.T) {
+	nibbles := "8.8.8.8.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.6.8.4.0.6.8.4.1.0.0.2"
+	for _, test := range []struct {
+		name     string
+		ip       string
+		inZone   bool
+		exists   bool
+		describe string
+	}{
+		{"4.4.8.8.origin.geo.local.", "8.8.4.4", true, true, "reversed octets"},
+		{"8.8.4.4.ip.GEO.local", "8.8.4.4", true, true, "forward octets, case-insensitive"},
+		{nibbles + ".origin6.geo.local.", "2001:4860:4860::8888", true, true, "IPv6 nibbles"},
+		{"geo.local.", "", true, true, "zone apex"},
+		{"origin.geo.local.", "", true, true, "form label"},
+		{"1.2.3.origin.geo.local.", "", true, false, "too few octets"},
+		{"1.2.3.256.origin.geo.local.", "", true, false, "invalid octet"},
+		{"8.8.8.8.unknown.geo.local.", "", true, false, "unknown form"},
+		{"1.2.origin6.geo.local.", "", true, false, "too few nibbles"},
+		{"8.8.8.8.origin.example.com.", "", false, false, "other zone"},
+		{"notgeo.local.", "", false, false, "suffix without dot"},
+	} {
+		ip, inZone, exists := parseGeoQueryName(test.name, "geo.local")
+		got := ""
+		if ip != nil {
+			got = ip.String()
+		}
+		if got != test.ip || inZone != test.inZone || exists != test.exists {
+			t.Errorf("%s: expected %q %v %v, got %q %v %v", test.describe, test.ip, test.inZone, test.exists, got, inZone, exists)
+		}
+	}
+}
+
+func TestDNSServer(t *testing.T) {
+	originalDatabases := databases
+	originalConfig := config
+	defer func() {
+		databases = originalDatabases
+		config = originalConfig
+	}()
+	config = defaultConfig
+	config.DNSServer.TTL = Duration(5 * time.Minute)
+	databases = map[string]*dbConfig{"asn": {reader: &MockReader{}}, "country": {reader: &MockReader{}}}
+
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers := newDNSServers(packetConn, listener)
+	for _, server := range servers {
+		started := make(chan struct{})
+		server.NotifyStartedFunc = func() { close(started) }
+		go server.ActivateAndServe()
+		<-started
+		defer server.Shutdown()
+	}
+
+	query := func(network, addr, name string, qtype uint16) *dns.Msg {
+		t.Helper()
+		msg := new(dns.Msg)
+		msg.SetQuestion(name, qtype)
+		response, _, err := (&dns.Client{Net: network}).Exchange(msg, addr)
+		if err != nil {
+			t.Fatalf("%s query for %s failed: %v", network, name, err)
+		}
+		return response
+	}
+
+	for network, addr := range map[string]string{"udp": packetConn.LocalAddr().String(), "tcp": listener.Addr().String()} {
+		response := query(network, addr, "4.4.8.8.origin.geo.local.", dns.TypeTXT)
+		if response.Rcode != dns.RcodeSuccess || !response.Authoritative || len(response.Answer) != 1 {
+			t.Fatalf("Expected one authoritative answer over %s, got %v", network, response)
+		}
+		txt := response.Answer[0].(*dns.TXT)
+		if expected := "12345 | 8.8.4.0/24 | TS | Test ISP"; strings.Join(txt.Txt, "") != expected {
+			t.Errorf("Expected %q, got %q", expected, txt.Txt)
+		}
+		if txt.Hdr.Ttl != 300 {
+			t.Errorf("Expected TTL 300, got %d", txt.Hdr.Ttl)
+		}
+	}
+
+	udp := packetConn.LocalAddr().String()
+	if response := query("udp", udp, "4.4.8.8.origin.geo.local.", dns.TypeA); response.Rcode != dns.RcodeSuccess || len(response.Answer) != 0 {
+		t.Errorf("Expected empty answer for A query, got %v", response)
+	}
+	if response := query("udp", udp, "1.2.3.origin.geo.local.", dns.TypeTXT); response.Rcode != dns.RcodeNameError {
+		t.Errorf("Expected NXDOMAIN for incomplete address, got %v", response)
+	}
+	if response := query("udp", udp, "origin.geo.local.", dns.TypeTXT); response.Rcode != dns.RcodeSuccess || len(response.Answer) != 0 {
+		t.Errorf("Expected empty answer for form label, got %v", response)
+	}
+	if response := query("udp", udp, "example.com.", dns.TypeTXT); response.Rcode != dns.RcodeRefused {
+		t.Errorf("Expected names outside the zone to be refused, got %v", response)
+	}
+}
+
+func TestValidateDNSServer(t *testing.T) {
+	for _, test := range []struct {
+		server   DNSServerConfig
+		expected string
+	}{
+		{DNSServerConfig{Enabled: true, Port: defaultConfig.Port, Zone: "geo.local"}, "must differ"},
+		{DNSServerConfig{Enabled: true, Port: "8053", Zone: ""}, "dns_server.zone"},
+		{DNSServerConfig{Enabled: true, Port: "8053", Zone: "geo.local", TTL: -1}, "dns_server.ttl"},
+	} {
+		cfg := defaultConfig
+		cfg.DNSServer = test.server
+		if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("Expected %q for %+v, got %v", test.expected, test.server, err)
+		}
+	}
+
+	cfg := defaultConfig
+	cfg.DNSServer.Enabled = true
+	if err := validateConfig(cfg); err != nil {
+		t.Errorf("Expected defaults to be valid, got %v", err)
+	}
+}
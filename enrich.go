@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// enrichIPPattern finds IPv4 and IPv6 candidates in log lines; candidates
+// that don't parse as an address are skipped
+const enrichIPPattern = `\d{1,3}(?:\.\d{1,3}){3}|[0-9A-Fa-f]{0,4}:[0-9A-Fa-f:.]*:[0-9A-Fa-f.]*`
+
+// Records are handed to the workers in batches of this size
+const enrichBatchSize = 256
+
+// enrichOptions configures the enrich subcommand
+type enrichOptions struct {
+	format  string         // text, json or csv
+	field   string         // JSON field (dotted for nested objects) or CSV column holding the address
+	pattern *regexp.Regexp // Finds the address in text lines; the first group if it has one
+	fields  []string       // IPInfo fields appended to each record
+	prefix  string         // Prefix of the appended field names
+	workers int
+}
+
+// enrichBatch is a run of consecutive input records and, once processed,
+// their output
+type enrichBatch struct {
+	lines  []string   // text and json
+	rows   [][]string // csv
+	result chan []byte
+}
+
+// runEnrich implements the enrich subcommand: it reads records from stdin
+// and writes them to stdout with geo fields appended
+func runEnrich(args []string) int {
+	var (
+		opts     enrichOptions
+		pattern  string
+		fields   string
+		download bool
+	)
+	flag.StringVar(&opts.format, "format", "text", "Input format: text (log lines), json (JSON lines) or csv (with a header row)")
+	flag.StringVar(&opts.field, "field", "ip", "JSON field or CSV column holding the address; nested JSON fields as a.b")
+	flag.StringVar(&pattern, "regex", enrichIPPattern, "Regular expression finding the address in text lines; the first group is used if it has one")
+	flag.StringVar(&fields, "fields", "country_code,city,asn,org", "Comma-separated IPInfo fields to append")
+	flag.StringVar(&opts.prefix, "prefix", "geo_", "Prefix of the appended field names")
+	flag.IntVar(&opts.workers, "workers", runtime.NumCPU(), "Number of records looked up in parallel")
+	flag.BoolVar(&download, "download", false, "Download missing databases instead of failing")
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return 2
+	}
+
+	if opts.format != "text" && opts.format != "json" && opts.format != "csv" {
+		fmt.Fprintf(os.Stderr, "unknown format %q, expected text, json or csv\n", opts.format)
+		return 2
+	}
+	var err error
+	if opts.pattern, err = regexp.Compile(pattern); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid regex: %v\n", err)
+		return 2
+	}
+	if opts.fields, err = parseIPInfoFields(fields); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if opts.workers < 1 {
+		opts.workers = 1
+	}
+
+	if err := setupCLI(download); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	err = enrich(os.Stdin, out, opts)
+	if flushErr := out.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// parseIPInfoFields splits a comma-separated list of IPInfo JSON field names
+func parseIPInfoFields(list string) ([]string, error) {
+	known := map[string]bool{}
+	infoType := reflect.TypeOf(IPInfo{})
+	for i := 0; i < infoType.NumField(); i++ {
+		if name, _, _ := strings.Cut(infoType.Field(i).Tag.Get("json"), ","); name != "" && name != "-" {
+			known[name] = true
+		}
+	}
+
+	var fields []string
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !known[field] {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		fields = append(fields, field)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no fields given")
+	}
+	return fields, nil
+}
+
+// enrich copies records from r to w with the geo fields appended, looking
+// them up on opts.workers goroutines while keeping the input order
+func enrich(r io.Reader, w io.Writer, opts enrichOptions) error {
+	var (
+		lines  *bufio.Reader
+		rows   *csv.Reader
+		column int
+	)
+	if opts.format != "csv" {
+		lines = bufio.NewReader(r)
+	} else {
+		rows = csv.NewReader(r)
+		rows.FieldsPerRecord = -1
+		header, err := rows.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		column = slices.Index(header, opts.field)
+		if column < 0 {
+			return fmt.Errorf("column %q not found", opts.field)
+		}
+		for _, field := range opts.fields {
+			header = append(header, opts.prefix+field)
+		}
+		if err := writeCSV(w, [][]string{header}); err != nil {
+			return err
+		}
+	}
+
+	jobs := make(chan *enrichBatch)
+	pending := make(chan *enrichBatch, opts.workers*2)
+	for i := 0; i < opts.workers; i++ {
+		go func() {
+			for batch := range jobs {
+				batch.result <- enrichProcess(batch, opts, column)
+			}
+		}()
+	}
+
+	// Read batches in order; the loop below writes them in the same order
+	// as their results come in
+	var readErr error
+	go func() {
+		defer close(jobs)
+		defer close(pending)
+		for {
+			batch := &enrichBatch{result: make(chan []byte, 1)}
+			for len(batch.lines)+len(batch.rows) < enrichBatchSize {
+				if rows != nil {
+					row, err := rows.Read()
+					if err != nil {
+						if err != io.EOF {
+							readErr = err
+						}
+						break
+					}
+					batch.rows = append(batch.rows, row)
+				} else {
+					line, err := lines.ReadString('\n')
+					if line != "" {
+						batch.lines = append(batch.lines, strings.TrimRight(line, "\r\n"))
+					}
+					if err != nil {
+						if err != io.EOF {
+							readErr = err
+						}
+						break
+					}
+				}
+			}
+			if len(batch.lines)+len(batch.rows) == 0 {
+				return
+			}
+			pending <- batch
+			jobs <- batch
+			if len(batch.lines)+len(batch.rows) < enrichBatchSize {
+				return
+			}
+		}
+	}()
+
+	var writeErr error
+	for batch := range pending {
+		output := <-batch.result
+		if writeErr == nil {
+			_, writeErr = w.Write(output)
+		}
+	}
+	if readErr != nil {
+		return readErr
+	}
+	return writeErr
+}
+
+// enrichProcess looks up and formats the records of a batch
+func enrichProcess(batch *enrichBatch, opts enrichOptions, column int) []byte {
+	var buf bytes.Buffer
+	switch opts.format {
+	case "text":
+		for _, line := range batch.lines {
+			buf.WriteString(line)
+			if values := enrichLookup(findIP(line, opts.pattern), opts.fields); values != nil {
+				for i, field := range opts.fields {
+					buf.WriteString(" " + opts.prefix + field + "=" + logfmtValue(textValue(values[i])))
+				}
+			}
+			buf.WriteByte('\n')
+		}
+	case "json":
+		for _, line := range batch.lines {
+			buf.Write(enrichJSONLine(line, opts))
+			buf.WriteByte('\n')
+		}
+	case "csv":
+		for i, row := range batch.rows {
+			address := ""
+			if column < len(row) {
+				address = row[column]
+			}
+			values := enrichLookup(address, opts.fields)
+			for j := range opts.fields {
+				value := ""
+				if values != nil {
+					value = textValue(values[j])
+				}
+				row = append(row, value)
+			}
+			batch.rows[i] = row
+		}
+		writeCSV(&buf, batch.rows)
+	}
+	return buf.Bytes()
+}
+
+// enrichLookup returns the JSON values of the fields for an address, or nil
+// if it isn't one or can't be looked up
+func enrichLookup(address string, fields []string) []json.RawMessage {
+	ip := net.ParseIP(strings.TrimSpace(address))
+	if ip == nil {
+		return nil
+	}
+	info, err := getIPInfo(ip)
+	if err != nil {
+		logger.Warn("Error getting IP info", "ip", maskIP(address), "error", err)
+		return nil
+	}
+	return ipInfoValues(info, fields)
+}
+
+// ipInfoValues returns the JSON values of IPInfo fields as they appear in
+// API responses, null for fields that are left out
+func ipInfoValues(info *IPInfo, fields []string) []json.RawMessage {
+	var all map[string]json.RawMessage
+	if data, err := json.Marshal(info); err == nil {
+		json.Unmarshal(data, &all)
+	}
+
+	values := make([]json.RawMessage, len(fields))
+	for i, field := range fields {
+		values[i] = all[field]
+		if values[i] == nil {
+			values[i] = json.RawMessage("null")
+		}
+	}
+	return values
+}
+
+// findIP returns the first match of pattern in line that is an address
+func findIP(line string, pattern *regexp.Regexp) string {
+	for _, match := range pattern.FindAllStringSubmatch(line, -1) {
+		candidate := match[0]
+		if len(match) > 1 {
+			candidate = match[1]
+		}
+		if net.ParseIP(candidate) != nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// enrichJSONLine appends the fields to a JSON object, keeping the line as
+// it was otherwise. Lines that aren't objects are returned unchanged.
+func enrichJSONLine(line string, opts enrichOptions) []byte {
+	var record map[string]any
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		return []byte(line)
+	}
+	var value any = record
+	for _, key := range strings.Split(opts.field, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			value = nil
+			break
+		}
+		value = object[key]
+	}
+	address, _ := value.(string)
+	values := enrichLookup(address, opts.fields)
+	if values == nil {
+		return []byte(line)
+	}
+
+	trimmed := strings.TrimRight(line, " \t")
+	var buf bytes.Buffer
+	buf.WriteString(strings.TrimSuffix(trimmed, "}"))
+	for i, field := range opts.fields {
+		if i > 0 || len(record) > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(opts.prefix + field)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(values[i])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+// textValue formats a JSON value for text and CSV output: strings without
+// quotes, null as empty, anything else as JSON
+func textValue(value json.RawMessage) string {
+	var s string
+	if json.Unmarshal(value, &s) == nil {
+		return s
+	}
+	if string(value) == "null" {
+		return ""
+	}
+	return string(value)
+}
+
+// logfmtValue quotes a value if it contains spaces, quotes or equals signs
+func logfmtValue(value string) string {
+	if strings.ContainsAny(value, " \t\"=") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+func writeCSV(w io.Writer, rows [][]string) error {
+	writer := csv.NewWriter(w)
+	writer.WriteAll(rows)
+	return writer.Error()
+}
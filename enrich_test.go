@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func setupEnrich(t *testing.T, format string) enrichOptions {
+	t.Helper()
+	originalDatabases := databases
+	originalConfig := config
+	t.Cleanup(func() {
+		databases = originalDatabases
+		config = originalConfig
+	})
+	config = defaultConfig
+	databases = map[string]*dbConfig{"asn": {reader: &MockReader{}}, "country": {reader: &MockReader{}}}
+
+	return enrichOptions{
+		format:  format,
+		field:   "ip",
+		pattern: regexp.MustCompile(enrichIPPattern),
+		fields:  []string{"country_code", "asn", "org"},
+		prefix:  "geo_",
+		workers: 4,
+	}
+}
+
+func runEnrichTest(t *testing.T, input string, opts enrichOptions) string {
+	t.Helper()
+	var out bytes.Buffer
+	if err := enrich(strings.NewReader(input), &out, opts); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestEnrichText(t *testing.T) {
+	opts := setupEnrich(t, "text")
+
+	input := "81.2.69.142 - - [10/Oct/2026:13:55:36 +0000] \"GET / HTTP/1.1\" 200\r\n" +
+		"at 10:15:22 client=2a02:2e0::1 port=443\n" +
+		"no address here\n" +
+		"999.1.1.1 only an invalid one"
+	expected := "81.2.69.142 - - [10/Oct/2026:13:55:36 +0000] \"GET / HTTP/1.1\" 200 geo_country_code=TS geo_asn=AS12345 geo_org=\"Test ISP\"\n" +
+		"at 10:15:22 client=2a02:2e0::1 port=443 geo_country_code=TS geo_asn=AS12345 geo_org=\"Test ISP\"\n" +
+		"no address here\n" +
+		"999.1.1.1 only an invalid one\n"
+	if got := runEnrichTest(t, input, opts); got != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, got)
+	}
+
+	// A group picks the address out of a larger match
+	opts.pattern = regexp.MustCompile(`client=(\S+)`)
+	if got := runEnrichTest(t, "from 192.0.2.1 client=2.160.0.1\n", opts); !strings.HasPrefix(got, "from 192.0.2.1 client=2.160.0.1 geo_country_code=TS") {
+		t.Errorf("Expected the grouped address to be used, got %q", got)
+	}
+}
+
+func TestEnrichJSON(t *testing.T) {
+	opts := setupEnrich(t, "json")
+	opts.field = "client.ip"
+
+	input := `{"client": {"ip": "81.2.69.142"}, "status": 200}` + "\n" +
+		`{"client": {"ip": "not an ip"}}` + "\n" +
+		`not json` + "\n" +
+		`{"status": 404}` + "\n"
+	expected := `{"client": {"ip": "81.2.69.142"}, "status": 200,"geo_country_code":"TS","geo_asn":"AS12345","geo_org":"Test ISP"}` + "\n" +
+		`{"client": {"ip": "not an ip"}}` + "\n" +
+		`not json` + "\n" +
+		`{"status": 404}` + "\n"
+	if got := runEnrichTest(t, input, opts); got != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, got)
+	}
+}
+
+func TestEnrichCSV(t *testing.T) {
+	opts := setupEnrich(t, "csv")
+	opts.field = "addr"
+
+	input := "time,addr,path\n1,81.2.69.142,\"/a,b\"\n2,,/c\n3\n"
+	expected := "time,addr,path,geo_country_code,geo_asn,geo_org\n" +
+		"1,81.2.69.142,\"/a,b\",TS,AS12345,Test ISP\n" +
+		"2,,/c,,,\n" +
+		"3,,,\n"
+	if got := runEnrichTest(t, input, opts); got != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, got)
+	}
+
+	opts.field = "missing"
+	if err := enrich(strings.NewReader(input), &bytes.Buffer{}, opts); err == nil || !strings.Contains(err.Error(), `column "missing" not found`) {
+		t.Errorf("Expected missing column error, got %v", err)
+	}
+}
+
+func TestEnrichKeepsOrder(t *testing.T) {
+	opts := setupEnrich(t, "text")
+	opts.fields = []string{"ip"}
+	opts.prefix = "looked_up_"
+
+	// Several batches, each line with a different address
+	var input, expected strings.Builder
+	for i := 0; i < enrichBatchSize*5+17; i++ {
+		address := fmt.Sprintf("10.%d.%d.%d", i>>16&255, i>>8&255, i&255)
+		fmt.Fprintf(&input, "%d %s\n", i, address)
+		fmt.Fprintf(&expected, "%d %s looked_up_ip=%s\n", i, address, address)
+	}
+	if got := runEnrichTest(t, input.String(), opts); got != expected.String() {
+		t.Errorf("Expected output in input order")
+	}
+}
+
+func TestParseIPInfoFields(t *testing.T) {
+	fields, err := parseIPInfoFields("country_code, asn,,org")
+	if err != nil || strings.Join(fields, ",") != "country_code,asn,org" {
+		t.Errorf("Expected three fields, got %v %v", fields, err)
+	}
+	if _, err := parseIPInfoFields("country_code,nope"); err == nil || !strings.Contains(err.Error(), `"nope"`) {
+		t.Errorf("Expected unknown field error, got %v", err)
+	}
+	if _, err := parseIPInfoFields(" , "); err == nil {
+		t.Error("Expected error for an empty list")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ExtAuthzConfig configures the Envoy ext_authz v3 gRPC server. Decisions
+// use the forward_auth policy or lists.
+type ExtAuthzConfig struct {
+	Enabled bool   `json:"enabled"`
+	Port    string `json:"port"` // Separate from the HTTP port
+}
+
+var defaultExtAuthzConfig = ExtAuthzConfig{
+	Port: "5325",
+}
+
+// extAuthzServer implements envoy.service.auth.v3.Authorization
+type extAuthzServer struct {
+	authv3.UnimplementedAuthorizationServer
+}
+
+// Check decides on the downstream address of a request. Allowed requests
+// get the X-Geo-* headers added upstream; headers without a value are
+// removed so clients can't supply their own.
+func (extAuthzServer) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	p, err := currentForwardAuthPolicy()
+	if err != nil {
+		logger.Error("ext_authz policy not found", "policy", config.ForwardAuth.Policy)
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+
+	address := req.GetAttributes().GetSource().GetAddress().GetSocketAddress().GetAddress()
+	ip := net.ParseIP(address)
+	if ip == nil {
+		logger.Debug("Denying ext_authz request with invalid downstream address", "ip", address)
+		return deniedCheckResponse(nil), nil
+	}
+	info, err := getIPInfo(ip)
+	if err != nil {
+		logger.Error("Error getting IP info", "ip", maskIP(address), "error", err)
+		return nil, status.Errorf(codes.Internal, "error getting IP info: %v", err)
+	}
+	decision := p.evaluate(info, ip)
+
+	var headers []*corev3.HeaderValueOption
+	var remove []string
+	geo := geoHeaders(info, decision)
+	names := make([]string, 0, len(geo))
+	for name := range geo {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if geo[name] == "" {
+			remove = append(remove, name)
+			continue
+		}
+		headers = append(headers, &corev3.HeaderValueOption{
+			Header:       &corev3.HeaderValue{Key: name, Value: geo[name]},
+			AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+		})
+	}
+
+	logger.Debug("ext_authz decision", "ip", maskIP(address), "country", info.CountryCode, "decision", decision.Decision)
+	if decision.Decision != "allow" {
+		return deniedCheckResponse(headers), nil
+	}
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{
+			OkResponse: &authv3.OkHttpResponse{Headers: headers, HeadersToRemove: remove},
+		},
+	}, nil
+}
+
+// deniedCheckResponse makes Envoy answer 403, with the geo headers sent to
+// the client
+func deniedCheckResponse(headers []*corev3.HeaderValueOption) *authv3.CheckResponse {
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(codes.PermissionDenied)},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: &authv3.DeniedHttpResponse{
+				Status:  &typev3.HttpStatus{Code: typev3.StatusCode_Forbidden},
+				Headers: headers,
+				Body:    http.StatusText(http.StatusForbidden),
+			},
+		},
+	}
+}
+
+// newExtAuthzServer returns a gRPC server with the Authorization service
+func newExtAuthzServer() *grpc.Server {
+	server := grpc.NewServer()
+	authv3.RegisterAuthorizationServer(server, extAuthzServer{})
+	return server
+}
+
+// startExtAuthz listens on the ext_authz port and serves in the background
+func startExtAuthz() error {
+	addr := fmt.Sprintf("%s:%s", config.Host, config.ExtAuthz.Port)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	logger.Info("Starting ext_authz gRPC server", "addr", addr)
+	go func() {
+		fatal("ext_authz server stopped", "error", newExtAuthzServer().Serve(listener))
+	}()
+	return nil
+}
+
+// checkExtAuthz validates the ext_authz section
+func (v *configValidator) checkExtAuthz(cfg Config) {
+	if !cfg.ExtAuthz.Enabled {
+		return
+	}
+	v.port("ext_authz.port", cfg.ExtAuthz.Port)
+	if cfg.ExtAuthz.Port == cfg.Port {
+		v.addf("ext_authz.port", "must differ from port")
+	}
+}
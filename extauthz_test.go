@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// extAuthzClient serves the Authorization service in-process and returns a
+// client for it
+func extAuthzClient(t *testing.T) authv3.AuthorizationClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := newExtAuthzServer()
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return authv3.NewAuthorizationClient(conn)
+}
+
+func checkRequest(address string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{Attributes: &authv3.AttributeContext{
+		Source: &authv3.AttributeContext_Peer{Address: &corev3.Address{Address: &corev3.Address_SocketAddress{
+			SocketAddress: &corev3.SocketAddress{Address: address, PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: 40000}},
+		}}},
+	}}
+}
+
+func headerValues(options []*corev3.HeaderValueOption) map[string]string {
+	values := map[string]string{}
+	for _, option := range options {
+		values[option.GetHeader().GetKey()] = option.GetHeader().GetValue()
+	}
+	return values
+}
+
+func TestExtAuthzCheck(t *testing.T) {
+	setupPolicies(t, testPolicies)
+	originalPolicy := forwardAuthPolicy
+	defer func() { forwardAuthPolicy = originalPolicy }()
+	client := extAuthzClient(t)
+	ctx := context.Background()
+
+	// The lists are shared with /auth, which doesn't need to be enabled
+	config.ForwardAuth = ForwardAuthConfig{AllowCountries: []string{"DE"}}
+	if err := setupForwardAuth(config.ForwardAuth); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := client.Check(ctx, checkRequest("2.160.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := response.GetOkResponse()
+	if codes.Code(response.GetStatus().GetCode()) != codes.OK || ok == nil {
+		t.Fatalf("Expected allowed request, got %v", response)
+	}
+	for header, expected := range map[string]string{
+		headerGeoCountry:  "DE",
+		headerGeoASN:      "AS12345",
+		headerGeoOrg:      "Test ISP",
+		headerGeoDecision: "allow",
+		headerGeoRule:     "allow_countries",
+	} {
+		if got := headerValues(ok.GetHeaders())[header]; got != expected {
+			t.Errorf("Expected %s %q, got %q", header, expected, got)
+		}
+	}
+	for _, option := range ok.GetHeaders() {
+		if option.GetAppendAction() != corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD {
+			t.Errorf("Expected %s to overwrite client headers", option.GetHeader().GetKey())
+		}
+	}
+	if !strings.Contains(strings.Join(ok.GetHeadersToRemove(), ","), headerGeoCity) {
+		t.Errorf("Expected empty %s to be removed, got %v", headerGeoCity, ok.GetHeadersToRemove())
+	}
+
+	response, err = client.Check(ctx, checkRequest("8.8.8.8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	denied := response.GetDeniedResponse()
+	if codes.Code(response.GetStatus().GetCode()) != codes.PermissionDenied || denied.GetStatus().GetCode() != 403 {
+		t.Errorf("Expected denied request, got %v", response)
+	}
+	if got := headerValues(denied.GetHeaders())[headerGeoCountry]; got != "US" {
+		t.Errorf("Expected denied response to carry the country, got %q", got)
+	}
+
+	// Requests without a usable downstream address are denied
+	response, err = client.Check(ctx, &authv3.CheckRequest{})
+	if err != nil || codes.Code(response.GetStatus().GetCode()) != codes.PermissionDenied {
+		t.Errorf("Expected request without address to be denied, got %v %v", response, err)
+	}
+
+	// A named policy can be used instead
+	config.ForwardAuth = ForwardAuthConfig{Policy: "eu-only"}
+	response, err = client.Check(ctx, checkRequest("192.168.1.10"))
+	if err != nil || response.GetOkResponse() == nil || headerValues(response.GetOkResponse().GetHeaders())[headerGeoRule] != "internal" {
+		t.Errorf("Expected policy to allow internal address, got %v %v", response, err)
+	}
+	config.ForwardAuth.Policy = "missing"
+	if _, err := client.Check(ctx, checkRequest("192.168.1.10")); status.Code(err) != codes.Internal {
+		t.Errorf("Expected internal error for a missing policy, got %v", err)
+	}
+}
+
+func TestValidateExtAuthz(t *testing.T) {
+	cfg := defaultConfig
+	cfg.ExtAuthz = ExtAuthzConfig{Enabled: true, Port: cfg.Port}
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "must differ from port") {
+		t.Errorf("Expected port conflict, got %v", err)
+	}
+	cfg.ExtAuthz.Port = "70000"
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "ext_authz.port") {
+		t.Errorf("Expected invalid port, got %v", err)
+	}
+	cfg.ExtAuthz.Port = "5325"
+	if err := validateConfig(cfg); err != nil {
+		t.Errorf("Expected valid configuration, got %v", err)
+	}
+}
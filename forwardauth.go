@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// ForwardAuthConfig configures GET /auth for nginx auth_request, Traefik
+// ForwardAuth and Caddy forward_auth
+type ForwardAuthConfig struct {
+	Enabled        bool     `json:"enabled"`
+	Policy         string   `json:"policy"`          // Named policy to evaluate instead of the lists below
+	AllowCountries []string `json:"allow_countries"` // Only these countries are allowed, if set
+	DenyCountries  []string `json:"deny_countries"`
+	AllowASNs      []string `json:"allow_asns"` // Only these AS numbers are allowed, if set
+	DenyASNs       []string `json:"deny_asns"`
+}
+
+// policy turns the allow and deny lists into a policy. Deny lists win;
+// with allow lists, addresses matching neither an allowed country nor an
+// allowed AS are denied.
+func (cfg ForwardAuthConfig) policy() (*Policy, error) {
+	denyASNs, err := parseASNs(cfg.DenyASNs)
+	if err != nil {
+		return nil, fmt.Errorf("deny_asns: %v", err)
+	}
+	allowASNs, err := parseASNs(cfg.AllowASNs)
+	if err != nil {
+		return nil, fmt.Errorf("allow_asns: %v", err)
+	}
+
+	p := &Policy{Default: "allow"}
+	if len(cfg.DenyCountries) > 0 {
+		p.Rules = append(p.Rules, PolicyRule{Name: "deny_countries", Action: "deny", Countries: append([]string{}, cfg.DenyCountries...)})
+	}
+	if len(denyASNs) > 0 {
+		p.Rules = append(p.Rules, PolicyRule{Name: "deny_asns", Action: "deny", ASNs: denyASNs})
+	}
+	if len(cfg.AllowCountries) > 0 {
+		p.Rules = append(p.Rules, PolicyRule{Name: "allow_countries", Action: "allow", Countries: append([]string{}, cfg.AllowCountries...)})
+		p.Default = "deny"
+	}
+	if len(allowASNs) > 0 {
+		p.Rules = append(p.Rules, PolicyRule{Name: "allow_asns", Action: "allow", ASNs: allowASNs})
+		p.Default = "deny"
+	}
+	return p, p.compile()
+}
+
+// parseASNs parses AS numbers given with or without the AS prefix
+func parseASNs(values []string) (asnList, error) {
+	numbers := make(asnList, 0, len(values))
+	for _, value := range values {
+		number, err := parseASN(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AS number %q", value)
+		}
+		numbers = append(numbers, number)
+	}
+	return numbers, nil
+}
+
+// forwardAuthPolicy is the policy built from the forward_auth lists
+var forwardAuthPolicy *Policy
+
+// setupForwardAuth builds the forward_auth policy
+func setupForwardAuth(cfg ForwardAuthConfig) error {
+	p, err := cfg.policy()
+	if err != nil {
+		return err
+	}
+	forwardAuthPolicy = p
+	return nil
+}
+
+// Response headers describing the client, for the proxy to pass upstream
+const (
+	headerGeoCountry  = "X-Geo-Country"
+	headerGeoCity     = "X-Geo-City"
+	headerGeoRegion   = "X-Geo-Region"
+	headerGeoASN      = "X-Geo-ASN"
+	headerGeoOrg      = "X-Geo-Org"
+	headerGeoDecision = "X-Geo-Decision"
+	headerGeoRule     = "X-Geo-Rule"
+)
+
+// currentForwardAuthPolicy returns the policy /auth and ext_authz decide
+// with: the named policy if one is configured, the lists otherwise
+func currentForwardAuthPolicy() (*Policy, error) {
+	if config.ForwardAuth.Policy == "" {
+		return forwardAuthPolicy, nil
+	}
+	p, ok := lookupPolicy(config.ForwardAuth.Policy)
+	if !ok {
+		return nil, fmt.Errorf("policy %q not found", config.ForwardAuth.Policy)
+	}
+	return p, nil
+}
+
+// geoHeaders returns the X-Geo-* headers describing a client; headers
+// without a value are empty
+func geoHeaders(info *IPInfo, decision policyDecision) map[string]string {
+	headers := map[string]string{
+		headerGeoCountry:  info.CountryCode,
+		headerGeoCity:     info.City,
+		headerGeoRegion:   info.RegionCode,
+		headerGeoASN:      info.ASN,
+		headerGeoOrg:      info.Org,
+		headerGeoDecision: decision.Decision,
+		headerGeoRule:     "",
+	}
+	if decision.Rule != nil {
+		headers[headerGeoRule] = decision.Rule.Name
+	}
+	return headers
+}
+
+// handleForwardAuth serves /auth: it looks up the client address, answers
+// 200 if it is allowed and 403 if not, and describes the client in X-Geo-*
+// headers
+func handleForwardAuth(w http.ResponseWriter, r *http.Request) {
+	reqLogger := requestLogger(r)
+	clientIP := getClientIP(r)
+
+	p, err := currentForwardAuthPolicy()
+	if err != nil {
+		reqLogger.Error("Forward auth policy not found", "policy", config.ForwardAuth.Policy)
+		http.Error(w, "Policy not found", http.StatusInternalServerError)
+		return
+	}
+
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		reqLogger.Debug("Denying forward auth request with invalid client IP", "ip", clientIP)
+		http.Error(w, "Invalid client IP", http.StatusForbidden)
+		return
+	}
+	info, err := getIPInfo(ip)
+	if err != nil {
+		reqLogger.Error("Error getting IP info", "ip", maskIP(clientIP), "error", err)
+		http.Error(w, fmt.Sprintf("Error getting IP info: %v", err), http.StatusInternalServerError)
+		return
+	}
+	decision := p.evaluate(info, ip)
+
+	header := w.Header()
+	for name, value := range geoHeaders(info, decision) {
+		if value != "" {
+			header.Set(name, value)
+		}
+	}
+
+	reqLogger.Debug("Forward auth decision", "ip", maskIP(clientIP), "country", info.CountryCode, "decision", decision.Decision)
+	if decision.Decision != "allow" {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// checkForwardAuth validates the forward_auth section
+func (v *configValidator) checkForwardAuth(cfg Config) {
+	auth := cfg.ForwardAuth
+	lists := len(auth.AllowCountries) + len(auth.DenyCountries) + len(auth.AllowASNs) + len(auth.DenyASNs)
+	if auth.Policy != "" && lists > 0 {
+		v.addf("forward_auth", "policy and allow/deny lists can't be combined")
+	}
+	if _, err := auth.policy(); err != nil {
+		v.addf("forward_auth", "%v", err)
+	}
+	if auth.Policy != "" {
+		if cfg.PoliciesFile == "" {
+			v.addf("forward_auth.policy", "requires policies_file")
+		} else if loaded, err := loadPolicies(cfg.PoliciesFile); err == nil && loaded[auth.Policy] == nil {
+			v.addf("forward_auth.policy", "policy %q not found in %s", auth.Policy, cfg.PoliciesFile)
+		}
+	}
+}
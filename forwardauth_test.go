@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestForwardAuth(t *testing.T) {
+	setupPolicies(t, testPolicies)
+	originalPolicy := forwardAuthPolicy
+	defer func() { forwardAuthPolicy = originalPolicy }()
+	trustProxies(t, "192.0.2.0/24") // httptest requests come from 192.0.2.1
+
+	request := func(clientIP string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/auth", nil)
+		r.Header.Set("X-Forwarded-For", clientIP)
+		w := httptest.NewRecorder()
+		handleRequest(w, r)
+		return w
+	}
+
+	// Disabled by default
+	if w := request("2.160.0.1"); w.Code != http.StatusForbidden || w.Header().Get(headerGeoDecision) != "" {
+		t.Errorf("Expected /auth to be unavailable when disabled, got %d", w.Code)
+	}
+
+	config.ForwardAuth = ForwardAuthConfig{Enabled: true, AllowCountries: []string{"DE", "gbr"}, DenyASNs: []string{"AS12345"}}
+	if err := setupForwardAuth(config.ForwardAuth); err != nil {
+		t.Fatal(err)
+	}
+	// Every test address is in AS12345, so the deny list wins
+	w := request("2.160.0.1")
+	if w.Code != http.StatusForbidden || w.Header().Get(headerGeoRule) != "deny_asns" {
+		t.Errorf("Expected denied AS, got %d %v", w.Code, w.Header())
+	}
+
+	config.ForwardAuth.DenyASNs = nil
+	setupForwardAuth(config.ForwardAuth)
+	w = request("2.160.0.1")
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected allowed country, got %d", w.Code)
+	}
+	for header, expected := range map[string]string{
+		headerGeoCountry:  "DE",
+		headerGeoCity:     "",
+		headerGeoASN:      "AS12345",
+		headerGeoOrg:      "Test ISP",
+		headerGeoDecision: "allow",
+		headerGeoRule:     "allow_countries",
+	} {
+		if got := w.Header().Get(header); got != expected {
+			t.Errorf("Expected %s %q, got %q", header, expected, got)
+		}
+	}
+	if w := request("8.8.8.8"); w.Code != http.StatusForbidden || w.Header().Get(headerGeoCountry) != "US" {
+		t.Errorf("Expected country outside the allow list to be denied with headers, got %d %v", w.Code, w.Header())
+	}
+	if w := request("not-an-ip"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected invalid client IP to be denied, got %d", w.Code)
+	}
+
+	// A named policy can be used instead
+	config.ForwardAuth = ForwardAuthConfig{Enabled: true, Policy: "eu-only"}
+	if w := request("192.168.1.10"); w.Code != http.StatusOK || w.Header().Get(headerGeoRule) != "internal" {
+		t.Errorf("Expected policy to allow internal address, got %d %v", w.Code, w.Header())
+	}
+	config.ForwardAuth.Policy = "missing"
+	if w := request("192.168.1.10"); w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected error for a missing policy, got %d", w.Code)
+	}
+}
+
+func TestValidateForwardAuth(t *testing.T) {
+	for _, test := range []struct {
+		auth     ForwardAuthConfig
+		policies string
+		expected string
+	}{
+		{ForwardAuthConfig{Policy: "eu-only", DenyCountries: []string{"RU"}}, "", "can't be combined"},
+		{ForwardAuthConfig{AllowCountries: []string{"XX"}}, "", "unknown country"},
+		{ForwardAuthConfig{DenyASNs: []string{"ASX"}}, "", "deny_asns"},
+		{ForwardAuthConfig{Policy: "eu-only"}, "", "requires policies_file"},
+		{ForwardAuthConfig{Policy: "missing"}, testPolicies, `policy "missing" not found`},
+	} {
+		cfg := defaultConfig
+		cfg.ForwardAuth = test.auth
+		if test.policies != "" {
+			cfg.PoliciesFile = setupPolicies(t, test.policies)
+		}
+		if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("Expected %q for %+v, got %v", test.expected, test.auth, err)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ListSource configures an IP list such as the Tor exit list, Spamhaus DROP,
+// a FireHOL set or an internal blocklist
+type ListSource struct {
+	URL             string            `json:"url"`                   // Download URL; empty for a list managed as a local file
+	File            string            `json:"file"`                  // Local path, defaults to <db_dir>/lists/<name>.txt
+	Format          string            `json:"format"`                // "text" (default) or "csv"
+	Column          int               `json:"column"`                // CSV column holding the address or prefix
+	RefreshInterval Duration          `json:"refresh_interval"`      // How often to refresh, defaults to update_interval
+	Headers         map[string]string `json:"headers" secret:"true"` // Extra download request headers
+}
+
+// List file parsers by format. They return the address or prefix fields;
+// comments and blank lines are already skipped.
+var listFormats = map[string]func(data []byte, column int) ([]string, error){
+	"text": parseTextList,
+	"csv":  parseCSVList,
+}
+
+// ipList is a loaded IP list
+type ipList struct {
+	name    string
+	source  ListSource
+	path    string
+	refresh time.Duration
+
+	mutex     sync.RWMutex
+	table     *prefixTable[struct{}]
+	modTime   time.Time // Modification time of the loaded file
+	loadedAt  time.Time
+	lastError string
+}
+
+// Configured lists, sorted by name. Rebuilt from Config.Lists at startup.
+var ipLists []*ipList
+
+// configureLists builds the list registry from the configuration
+func configureLists(cfg Config) {
+	names := make([]string, 0, len(cfg.Lists))
+	for name := range cfg.Lists {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ipLists = make([]*ipList, 0, len(names))
+	for _, name := range names {
+		source := cfg.Lists[name]
+		list := &ipList{name: name, source: source, path: source.File, refresh: time.Duration(source.RefreshInterval)}
+		if list.path == "" {
+			list.path = filepath.Join(cfg.DBDir, "lists", name+".txt")
+		}
+		if list.refresh <= 0 {
+			list.refresh = time.Duration(cfg.UpdateInterval)
+		}
+		ipLists = append(ipLists, list)
+	}
+}
+
+// lookupLists fills in the names of every list containing ip
+func lookupLists(info *IPInfo, ip net.IP) {
+	if len(ipLists) == 0 {
+		info.omitDatabase("lists")
+		return
+	}
+
+	info.Lists = []string{}
+	for _, list := range ipLists {
+		list.mutex.RLock()
+		table := list.table
+		list.mutex.RUnlock()
+
+		if table == nil {
+			continue
+		}
+		if _, ok := table.lookup(ip); ok {
+			info.Lists = append(info.Lists, list.name)
+		}
+	}
+}
+
+// initLists downloads missing or outdated lists and loads every list
+func initLists() error {
+	for _, list := range ipLists {
+		if list.needsDownload() {
+			if err := list.download(); err != nil {
+				// An older copy is better than nothing
+				if _, statErr := os.Stat(list.path); statErr != nil {
+					return fmt.Errorf("list %s: %v", list.name, err)
+				}
+				logger.Error("Failed to download list, using existing file", "list", list.name, "error", err)
+			}
+		}
+		if err := list.load(); err != nil {
+			return fmt.Errorf("list %s: %v", list.name, err)
+		}
+	}
+	return nil
+}
+
+// startListUpdaters refreshes each list on its own interval
+func startListUpdaters() {
+	for _, list := range ipLists {
+		go func(list *ipList) {
+			ticker := time.NewTicker(list.refresh)
+			defer ticker.Stop()
+			for range ticker.C {
+				list.refreshNow()
+			}
+		}(list)
+	}
+}
+
+// reloadLists reloads every list whose file changed
+func reloadLists() error {
+	for _, list := range ipLists {
+		info, err := os.Stat(list.path)
+		if err != nil {
+			return fmt.Errorf("list %s: %v", list.name, err)
+		}
+
+		list.mutex.RLock()
+		unchanged := info.ModTime().Equal(list.modTime)
+		list.mutex.RUnlock()
+		if unchanged {
+			continue
+		}
+		if err := list.load(); err != nil {
+			return fmt.Errorf("list %s: %v", list.name, err)
+		}
+	}
+	return nil
+}
+
+// refreshNow downloads the list if it has a URL and reloads it if its file
+// changed. Failures are logged and the loaded list stays active.
+func (list *ipList) refreshNow() {
+	if list.source.URL != "" && !config.Offline {
+		if err := list.download(); err != nil {
+			logger.Error("Failed to download list", "list", list.name, "error", err)
+			list.setError(err)
+			return
+		}
+	}
+
+	info, err := os.Stat(list.path)
+	if err != nil {
+		list.setError(err)
+		return
+	}
+	list.mutex.RLock()
+	unchanged := info.ModTime().Equal(list.modTime)
+	list.mutex.RUnlock()
+	if unchanged {
+		return
+	}
+
+	if err := list.load(); err != nil {
+		logger.Error("Failed to reload list", "list", list.name, "error", err)
+	}
+}
+
+// needsDownload reports whether the list file is missing or older than the
+// refresh interval and can be downloaded
+func (list *ipList) needsDownload() bool {
+	if list.source.URL == "" || config.Offline {
+		return false
+	}
+	info, err := os.Stat(list.path)
+	return err != nil || time.Since(info.ModTime()) >= list.refresh
+}
+
+// download replaces the list file with a fresh copy
+func (list *ipList) download() error {
+	if err := os.MkdirAll(filepath.Dir(list.path), 0755); err != nil {
+		return err
+	}
+	tempPath := list.path + ".new"
+	if err := downloadDatabase(list.source.URL, tempPath, list.source.Headers); err != nil {
+		return err
+	}
+	if err := os.Rename(tempPath, list.path); err != nil {
+		return err
+	}
+	logger.Info("Downloaded list", "list", list.name)
+	return nil
+}
+
+// load parses the list file and swaps in the new entries
+func (list *ipList) load() error {
+	info, err := os.Stat(list.path)
+	if err != nil {
+		list.setError(err)
+		return err
+	}
+	table, err := loadListFile(list.path, list.source)
+	if err != nil {
+		list.setError(err)
+		return err
+	}
+
+	list.mutex.Lock()
+	list.table = table
+	list.modTime = info.ModTime()
+	list.loadedAt = time.Now()
+	list.lastError = ""
+	list.mutex.Unlock()
+
+	logger.Info("Loaded list", "list", list.name, "entries", table.len())
+	return nil
+}
+
+func (list *ipList) setError(err error) {
+	list.mutex.Lock()
+	list.lastError = err.Error()
+	list.mutex.Unlock()
+}
+
+// loadListFile reads a list file into a prefix table
+func loadListFile(path string, source ListSource) (*prefixTable[struct{}], error) {
+	format := listFormat(source, path)
+	parse, ok := listFormats[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown list format %q", format)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := parse(data, source.Column)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	table := newPrefixTable[struct{}]()
+	for i, field := range fields {
+		prefix, err := parsePrefix(field)
+		if err != nil {
+			// Tolerate a CSV header row
+			if i == 0 && format == "csv" {
+				continue
+			}
+			return nil, fmt.Errorf("%s: invalid address or prefix %q", path, field)
+		}
+		table.set(prefix, struct{}{})
+	}
+	return table, nil
+}
+
+// listFormat returns the configured format, or guesses it from the file name
+func listFormat(source ListSource, path string) string {
+	if source.Format != "" {
+		return source.Format
+	}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return "csv"
+	}
+	return "text"
+}
+
+// parseTextList reads one address or prefix per line. Anything after the
+// first field is ignored, as are comments starting with # or ; like in the
+// Spamhaus DROP and FireHOL lists.
+func parseTextList(data []byte, _ int) ([]string, error) {
+	var fields []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		if parts := strings.Fields(line); len(parts) > 0 {
+			fields = append(fields, parts[0])
+		}
+	}
+	return fields, scanner.Err()
+}
+
+// parseCSVList reads the addresses or prefixes from one column of a CSV file
+func parseCSVList(data []byte, column int) ([]string, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var fields []string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return fields, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if column < len(record) && record[column] != "" {
+			fields = append(fields, strings.TrimSpace(record[column]))
+		}
+	}
+}
+
+// listStatus is an entry of the GET /lists response
+type listStatus struct {
+	Name            string     `json:"name"`
+	Source          string     `json:"source"`
+	Entries         int        `json:"entries"`
+	Updated         *time.Time `json:"updated"`
+	Loaded          *time.Time `json:"loaded"`
+	RefreshInterval Duration   `json:"refresh_interval"`
+	Error           string     `json:"error,omitempty"`
+}
+
+// handleLists serves GET /lists with the size and freshness of every list
+func handleLists(w http.ResponseWriter, r *http.Request) {
+	statuses := make([]listStatus, 0, len(ipLists))
+	for _, list := range ipLists {
+		status := listStatus{
+			Name:            list.name,
+			Source:          list.source.URL,
+			RefreshInterval: Duration(list.refresh),
+		}
+		if status.Source == "" {
+			status.Source = list.path
+		}
+
+		list.mutex.RLock()
+		if list.table != nil {
+			status.Entries = list.table.len()
+			modTime, loadedAt := list.modTime, list.loadedAt
+			status.Updated, status.Loaded = &modTime, &loadedAt
+		}
+		status.Error = list.lastError
+		list.mutex.RUnlock()
+
+		statuses = append(statuses, status)
+	}
+	writeJSON(w, r, statuses)
+}
+
+// checkLists validates the lists section
+func (v *configValidator) checkLists(lists map[string]ListSource) {
+	for name, source := range lists {
+		field := "lists." + name
+		if name == "" || strings.ContainsAny(name, `/\`) {
+			v.addf("lists", "invalid list name %q", name)
+		}
+		if source.URL == "" && source.File == "" {
+			v.addf(field, "url or file is required")
+		}
+		if source.URL != "" {
+			v.url(field+".url", source.URL)
+		} else if source.File != "" {
+			v.fileExists(field+".file", source.File)
+		}
+		if source.Format != "" {
+			if _, ok := listFormats[source.Format]; !ok {
+				v.addf(field+".format", "unknown format %q, expected text or csv", source.Format)
+			}
+		}
+		if source.Column < 0 {
+			v.addf(field+".column", "must not be negative")
+		}
+		if source.RefreshInterval < 0 {
+			v.addf(field+".refresh_interval", "must not be negative")
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadListFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		// Spamhaus DROP
+		"drop.txt": "; Spamhaus DROP List 2024/01/01\n1.10.16.0/20 ; SBL256894\n2.56.192.0/22 ; SBL459831\n",
+		// FireHOL netset
+		"firehol.netset": "#\n# firehol_level1\n#\n0.0.0.0/8\n192.0.2.0/24\n",
+		// Tor bulk exit list
+		"tor.txt": "185.220.101.1\n2a0b:f4c2::1\n",
+		// CSV with a header row
+		"blocklist.csv": "ip,reason\n203.0.113.7,scanner\n198.51.100.0/24,botnet\n",
+	}
+	expected := map[string]int{"drop.txt": 2, "firehol.netset": 2, "tor.txt": 2, "blocklist.csv": 2}
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(content), 0644)
+		table, err := loadListFile(path, ListSource{})
+		if err != nil {
+			t.Errorf("%s: loadListFile failed: %v", name, err)
+			continue
+		}
+		if table.len() != expected[name] {
+			t.Errorf("%s: expected %d entries, got %d", name, expected[name], table.len())
+		}
+	}
+
+	// The CSV column is configurable
+	path := filepath.Join(dir, "second.csv")
+	os.WriteFile(path, []byte("scanner,203.0.113.7\n"), 0644)
+	table, err := loadListFile(path, ListSource{Column: 1})
+	if err != nil || table.len() != 1 {
+		t.Errorf("Expected 1 entry from column 1, got %v (%v)", table, err)
+	}
+
+	// Garbage is an error
+	path = filepath.Join(dir, "bad.txt")
+	os.WriteFile(path, []byte("203.0.113.7\nnot-an-ip\n"), 0644)
+	if _, err := loadListFile(path, ListSource{}); err == nil {
+		t.Error("Expected error for invalid entry")
+	}
+}
+
+func TestLists(t *testing.T) {
+	originalConfig := config
+	originalLists := ipLists
+	originalDatabases := databases
+	defer func() {
+		config = originalConfig
+		ipLists = originalLists
+		databases = originalDatabases
+	}()
+
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		w.Write([]byte("185.220.101.1\n185.220.101.2\n"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	dropPath := filepath.Join(dir, "drop.txt")
+	os.WriteFile(dropPath, []byte("185.220.0.0/16 ; SBL1\n"), 0644)
+
+	config = defaultConfig
+	config.DBDir = dir
+	config.Lists = map[string]ListSource{
+		"tor":  {URL: server.URL, Headers: map[string]string{"Authorization": "Bearer token"}},
+		"drop": {File: dropPath, RefreshInterval: Duration(time.Hour)},
+	}
+	configureLists(config)
+	if err := initLists(); err != nil {
+		t.Fatalf("initLists failed: %v", err)
+	}
+	if headers.Get("Authorization") != "Bearer token" {
+		t.Errorf("Expected download headers to be sent, got %v", headers)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "lists", "tor.txt")); err != nil {
+		t.Errorf("Expected list to be downloaded to the default path: %v", err)
+	}
+
+	databases = map[string]*dbConfig{}
+	info, err := getIPInfo(net.ParseIP("185.220.101.1"))
+	if err != nil {
+		t.Fatalf("getIPInfo failed: %v", err)
+	}
+	if !reflect.DeepEqual(info.Lists, []string{"drop", "tor"}) {
+		t.Errorf("Expected lists [drop tor], got %v", info.Lists)
+	}
+
+	info, _ = getIPInfo(net.ParseIP("81.2.69.142"))
+	data, _ := json.Marshal(info)
+	if !strings.Contains(string(data), `"lists":[]`) {
+		t.Errorf("Expected empty lists array, got %s", data)
+	}
+
+	// A changed local file is picked up on refresh, and private ranges
+	// match private addresses
+	os.WriteFile(dropPath, []byte("81.2.69.0/24\n10.0.0.0/8\n"), 0644)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(dropPath, later, later)
+	ipLists[0].refreshNow()
+	info, _ = getIPInfo(net.ParseIP("81.2.69.142"))
+	if !reflect.DeepEqual(info.Lists, []string{"drop"}) {
+		t.Errorf("Expected refreshed drop list to match, got %v", info.Lists)
+	}
+	info, _ = getIPInfo(net.ParseIP("10.1.2.3"))
+	data, _ = json.Marshal(info)
+	if !strings.Contains(string(data), `"lists":["drop"]`) {
+		t.Errorf("Expected private address to match the drop list, got %s", data)
+	}
+
+	// GET /lists reports sizes and freshness
+	config.Host = ""
+	w := httptest.NewRecorder()
+	handleRequest(w, httptest.NewRequest("GET", "/lists", nil))
+	var statuses []listStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &statuses); err != nil {
+		t.Fatalf("Failed to decode /lists response: %v", err)
+	}
+	if len(statuses) != 2 || statuses[0].Name != "drop" || statuses[1].Entries != 2 || statuses[1].Updated == nil {
+		t.Errorf("Unexpected /lists response: %s", w.Body.String())
+	}
+	if statuses[0].RefreshInterval != Duration(time.Hour) || statuses[1].RefreshInterval != defaultConfig.UpdateInterval {
+		t.Errorf("Unexpected refresh intervals: %s", w.Body.String())
+	}
+
+	// Without lists the field is left out
+	ipLists = nil
+	info, _ = getIPInfo(net.ParseIP("81.2.69.142"))
+	data, _ = json.Marshal(info)
+	if strings.Contains(string(data), `"lists"`) {
+		t.Errorf("Expected lists to be omitted without configured lists, got %s", data)
+	}
+}
+
+func TestInitListsOffline(t *testing.T) {
+	originalConfig := config
+	originalLists := ipLists
+	defer func() {
+		config = originalConfig
+		ipLists = originalLists
+	}()
+
+	config = defaultConfig
+	config.Offline = true
+	config.DBDir = t.TempDir()
+	config.Lists = map[string]ListSource{"tor": {URL: "http://127.0.0.1:1/tor.txt"}}
+	configureLists(config)
+	if err := initLists(); err == nil || !strings.Contains(err.Error(), "list tor") {
+		t.Errorf("Expected missing list error in offline mode, got %v", err)
+	}
+}
+
+func TestValidateLists(t *testing.T) {
+	cfg := defaultConfig
+	cfg.Lists = map[string]ListSource{
+		"empty":  {},
+		"bad":    {URL: "example.com/list.txt", Format: "xml", Column: -1},
+		"a/b":    {URL: "https://example.com/list.txt"},
+		"manual": {File: "/nonexistent/list.txt"},
+	}
+	err := validateConfig(cfg)
+	for _, expected := range []string{"lists.empty: url or file", "lists.bad.url", "lists.bad.format", "lists.bad.column", `invalid list name "a/b"`, "lists.manual.file"} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected problem %q, got %v", expected, err)
+		}
+	}
+}
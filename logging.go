@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	mathrand "math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// LogConfig holds the logging configuration
+type LogConfig struct {
+	Level         string  `json:"level"`           // debug, info, warn or error
+	Format        string  `json:"format"`          // "text" (logfmt) or "json"
+	AccessLog     bool    `json:"access_log"`      // Whether to write one access log line per request
+	AccessLogFile string  `json:"access_log_file"` // Empty means stdout
+	SampleRate    float64 `json:"sample_rate"`     // Fraction of requests written to the access log (0 or 1 logs all)
+	MaskIPs       bool    `json:"mask_ips"`        // Mask client and looked-up IPs in all log output
+}
+
+// Default logging configuration values
+var defaultLogConfig = LogConfig{
+	Level:      "info",
+	Format:     "text",
+	AccessLog:  true,
+	SampleRate: 1,
+}
+
+// Application and access loggers. They are usable before setupLogging is
+// called so that tests and early startup code can log.
+var (
+	logger       = slog.New(slog.NewTextHandler(os.Stderr, nil))
+	accessLogger *slog.Logger
+	logCfg       = defaultLogConfig
+)
+
+type requestIDKey struct{}
+
+// parseLogLevel converts a configured level name into a slog.Level
+func parseLogLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level %q", level)
+}
+
+// newLogHandler creates a slog handler writing to w in the configured format
+func newLogHandler(w io.Writer, format string, level slog.Level) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(format) {
+	case "", "text", "logfmt":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", format)
+}
+
+// setupLogging configures the application and access loggers
+func setupLogging(cfg LogConfig) error {
+	level, err := parseLogLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+
+	handler, err := newLogHandler(os.Stderr, cfg.Format, level)
+	if err != nil {
+		return err
+	}
+	logger = slog.New(handler)
+	slog.SetDefault(logger)
+
+	accessLogger = nil
+	if cfg.AccessLog {
+		var out io.Writer = os.Stdout
+		if cfg.AccessLogFile != "" {
+			file, err := os.OpenFile(cfg.AccessLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return fmt.Errorf("failed to open access log file: %v", err)
+			}
+			out = file
+		}
+		accessHandler, err := newLogHandler(out, cfg.Format, slog.LevelInfo)
+		if err != nil {
+			return err
+		}
+		accessLogger = slog.New(accessHandler)
+	}
+
+	logCfg = cfg
+	return nil
+}
+
+// maskIP hides the host part of an IP address when IP masking is enabled.
+// IPv4 addresses keep their /24 and IPv6 addresses their /48 prefix.
+func maskIP(address string) string {
+	if !logCfg.MaskIPs {
+		return address
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return address
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// maskPath masks every path segment that is an IP address
+func maskPath(path string) string {
+	if !logCfg.MaskIPs {
+		return path
+	}
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = maskIP(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// newRequestID generates a random identifier for requests that don't carry one
+func newRequestID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// requestLogger returns the application logger annotated with the request ID
+func requestLogger(r *http.Request) *slog.Logger {
+	if id, ok := r.Context().Value(requestIDKey{}).(string); ok {
+		return logger.With("request_id", id)
+	}
+	return logger
+}
+
+// accessLogWriter records the status code and size of a response
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *accessLogWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.bytes += n
+	return n, err
+}
+
+// Flush lets streaming handlers flush through the access log writer
+func (w *accessLogWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// withAccessLog assigns a request ID to every request and writes a single
+// access log line once the response has been sent
+func withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestID := r.Header.Get("X-Request-ID")
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, requestID))
+
+		recorder := &accessLogWriter{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		if accessLogger == nil {
+			return
+		}
+		if logCfg.SampleRate > 0 && logCfg.SampleRate < 1 && mathrand.Float64() >= logCfg.SampleRate {
+			return
+		}
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		accessLogger.Info("access",
+			"method", r.Method,
+			"path", maskPath(r.URL.Path),
+			"status", status,
+			"bytes", recorder.bytes,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"client_ip", maskIP(getClientIP(r)),
+			"request_id", requestID,
+		)
+	})
+}
+
+// fatal logs an error and terminates the process
+func fatal(msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	tests := map[string]slog.Level{
+		"":      slog.LevelInfo,
+		"debug": slog.LevelDebug,
+		"INFO":  slog.LevelInfo,
+		"warn":  slog.LevelWarn,
+		"error": slog.LevelError,
+	}
+
+	for input, expected := range tests {
+		level, err := parseLogLevel(input)
+		if err != nil {
+			t.Errorf("parseLogLevel(%q) returned error: %v", input, err)
+		}
+		if level != expected {
+			t.Errorf("parseLogLevel(%q) = %v, expected %v", input, level, expected)
+		}
+	}
+
+	if _, err := parseLogLevel("verbose"); err == nil {
+		t.Error("Expected error for unknown log level, got nil")
+	}
+}
+
+func TestMaskIP(t *testing.T) {
+	originalLogCfg := logCfg
+	defer func() { logCfg = originalLogCfg }()
+
+	logCfg.MaskIPs = false
+	if got := maskIP("192.168.1.77"); got != "192.168.1.77" {
+		t.Errorf("Expected unmasked IP, got '%s'", got)
+	}
+
+	logCfg.MaskIPs = true
+	tests := map[string]string{
+		"192.168.1.77":           "192.168.1.0",
+		"2001:db8:abcd:12::1":    "2001:db8:abcd::",
+		"not-an-ip":              "not-an-ip",
+		"/ipgeo/8.8.4.4":         "/ipgeo/8.8.4.4", // paths are handled by maskPath
+		"::ffff:10.20.30.40":     "10.20.30.0",
+		"2001:db8:abcd:ffff::ff": "2001:db8:abcd::",
+	}
+	for input, expected := range tests {
+		if got := maskIP(input); got != expected {
+			t.Errorf("maskIP(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+
+	if got := maskPath("/ipgeo/8.8.4.4"); got != "/ipgeo/8.8.4.0" {
+		t.Errorf("Expected masked path '/ipgeo/8.8.4.0', got '%s'", got)
+	}
+}
+
+func TestWithAccessLog(t *testing.T) {
+	originalAccessLogger := accessLogger
+	originalLogCfg := logCfg
+	defer func() {
+		accessLogger = originalAccessLogger
+		logCfg = originalLogCfg
+	}()
+
+	var buf bytes.Buffer
+	accessLogger = slog.New(slog.NewJSONHandler(&buf, nil))
+	logCfg = LogConfig{AccessLog: true, SampleRate: 1, MaskIPs: true}
+
+	handler := withAccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("hello"))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/ipgeo/8.8.4.4", nil)
+	req.RemoteAddr = "192.0.2.55:12345"
+	req.Header.Set("X-Request-ID", "test-request")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Header().Get("X-Request-ID") != "test-request" {
+		t.Errorf("Expected X-Request-ID to be echoed, got '%s'", w.Header().Get("X-Request-ID"))
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Failed to parse access log line %q: %v", buf.String(), err)
+	}
+
+	expected := map[string]interface{}{
+		"method":     "GET",
+		"path":       "/ipgeo/8.8.4.0",
+		"status":     float64(http.StatusTeapot),
+		"bytes":      float64(5),
+		"client_ip":  "192.0.2.0",
+		"request_id": "test-request",
+	}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("Expected access log %s=%v, got %v", key, value, entry[key])
+		}
+	}
+	if _, ok := entry["duration_ms"]; !ok {
+		t.Error("Expected access log to contain duration_ms")
+	}
+
+	// A request without an ID gets a generated one
+	buf.Reset()
+	req = httptest.NewRequest(http.MethodGet, "/ipgeo", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Header().Get("X-Request-ID") == "" {
+		t.Error("Expected a generated X-Request-ID")
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// lookupOptions configures the lookup subcommand
+type lookupOptions struct {
+	format string // json or table
+	field  string // Print only this IPInfo field, one value per address
+	rdns   bool   // Add the reverse DNS hostname
+}
+
+// lookupResult is the outcome of looking up one argument
+type lookupResult struct {
+	query string
+	infos []*IPInfo
+	host  *hostnameResult // Set for hostnames
+}
+
+// runLookup implements the lookup subcommand: it prints the IPInfo of each
+// address or hostname argument using the local databases
+func runLookup(args []string) int {
+	var (
+		opts     lookupOptions
+		download bool
+	)
+	flag.StringVar(&opts.format, "format", "json", "Output format: json or table")
+	flag.StringVar(&opts.field, "field", "", "Print only this IPInfo field, e.g. country_code")
+	flag.BoolVar(&opts.rdns, "rdns", false, "Look up the reverse DNS hostname of each address (default dns.reverse_lookup)")
+	flag.BoolVar(&download, "download", false, "Download missing databases instead of failing")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s lookup [flags] <ip|host>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return 2
+	}
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		return 2
+	}
+	if opts.format != "json" && opts.format != "table" {
+		fmt.Fprintf(os.Stderr, "unknown format %q, expected json or table\n", opts.format)
+		return 2
+	}
+	if opts.field != "" {
+		if fields, err := parseIPInfoFields(opts.field); err != nil || len(fields) != 1 {
+			fmt.Fprintf(os.Stderr, "unknown field %q\n", opts.field)
+			return 2
+		}
+	}
+
+	if err := setupCLI(download); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	rdnsSet := false
+	flag.Visit(func(f *flag.Flag) { rdnsSet = rdnsSet || f.Name == "rdns" })
+	if !rdnsSet {
+		opts.rdns = dnsCfg.ReverseLookup
+	}
+
+	var results []lookupResult
+	status := 0
+	for _, query := range flag.Args() {
+		result, err := lookupArgument(query, opts.rdns)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", query, err)
+			status = 1
+			continue
+		}
+		results = append(results, result)
+	}
+
+	if err := printLookupResults(os.Stdout, results, opts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return status
+}
+
+// lookupArgument looks up an address, or every address of a hostname
+func lookupArgument(query string, rdns bool) (lookupResult, error) {
+	result := lookupResult{query: query}
+	ips := []net.IP{net.ParseIP(query)}
+	if ips[0] == nil {
+		if !isHostname(query) {
+			return result, fmt.Errorf("not an IP address or hostname")
+		}
+		var truncated bool
+		var err error
+		if ips, truncated, err = resolveHostname(query); err != nil {
+			return result, err
+		}
+		result.host = &hostnameResult{Hostname: strings.TrimSuffix(query, "."), Truncated: truncated}
+	}
+
+	for _, ip := range ips {
+		info, err := getIPInfo(ip)
+		if err != nil {
+			return result, fmt.Errorf("error getting IP info: %v", err)
+		}
+		if rdns {
+			addHostname(info, ip)
+		}
+		result.infos = append(result.infos, info)
+	}
+	if result.host != nil {
+		result.host.Addresses = result.infos
+	}
+	return result, nil
+}
+
+// Columns of the table output, by IPInfo field
+var lookupTableFields = []string{"ip", "country_code", "region", "city", "asn", "org"}
+
+// printLookupResults writes the results as indented JSON, one document per
+// argument, as a table, or as one field value per address
+func printLookupResults(w io.Writer, results []lookupResult, opts lookupOptions) error {
+	if opts.field != "" {
+		for _, result := range results {
+			for _, info := range result.infos {
+				if _, err := fmt.Fprintln(w, textValue(ipInfoValues(info, []string{opts.field})[0])); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	if opts.format == "table" {
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "QUERY\tIP\tCOUNTRY\tREGION\tCITY\tASN\tORG")
+		for _, result := range results {
+			for _, info := range result.infos {
+				values := ipInfoValues(info, lookupTableFields)
+				row := []string{result.query}
+				for _, value := range values {
+					row = append(row, textValue(value))
+				}
+				fmt.Fprintln(table, strings.Join(row, "\t"))
+			}
+		}
+		return table.Flush()
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	for _, result := range results {
+		var err error
+		if result.host != nil {
+			err = encoder.Encode(result.host)
+		} else {
+			err = encoder.Encode(result.infos[0])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
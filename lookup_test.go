@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestLookupArgument(t *testing.T) {
+	originalDNS := dnsCfg
+	originalDatabases := databases
+	originalConfig := config
+	defer func() {
+		setupDNS(originalDNS)
+		databases = originalDatabases
+		config = originalConfig
+	}()
+	config = defaultConfig
+	databases = map[string]*dbConfig{"asn": {reader: &MockReader{}}, "country": {reader: &MockReader{}}}
+	setupDNS(defaultDNSConfig)
+	resolver = &fakeResolver{ips: map[string][]net.IPAddr{
+		"example.com": {{IP: net.ParseIP("81.2.69.142")}, {IP: net.ParseIP("2.160.0.1")}},
+	}}
+
+	result, err := lookupArgument("81.2.69.142", false)
+	if err != nil || result.host != nil || len(result.infos) != 1 || result.infos[0].CountryCode != "TS" {
+		t.Fatalf("Expected one address, got %+v %v", result, err)
+	}
+
+	hostResult, err := lookupArgument("example.com", false)
+	if err != nil || hostResult.host == nil || hostResult.host.Hostname != "example.com" || len(hostResult.host.Addresses) != 2 {
+		t.Fatalf("Expected both addresses of the hostname, got %+v %v", hostResult, err)
+	}
+
+	if _, err := lookupArgument("missing.example.com", false); err == nil {
+		t.Error("Expected error for an unknown hostname")
+	}
+	if _, err := lookupArgument("not-an-ip", false); err == nil || !strings.Contains(err.Error(), "not an IP address or hostname") {
+		t.Errorf("Expected invalid argument error, got %v", err)
+	}
+
+	results := []lookupResult{result, hostResult}
+	print := func(opts lookupOptions) string {
+		t.Helper()
+		var out bytes.Buffer
+		if err := printLookupResults(&out, results, opts); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	// JSON: the address, then the hostname in the /ipgeo/{hostname} form
+	decoder := json.NewDecoder(strings.NewReader(print(lookupOptions{format: "json"})))
+	var info map[string]any
+	var host hostnameResult
+	if err := decoder.Decode(&info); err != nil || info["ip"] != "81.2.69.142" || info["asn"] != "AS12345" {
+		t.Errorf("Expected address document, got %v %v", info, err)
+	}
+	if err := decoder.Decode(&host); err != nil || host.Hostname != "example.com" || len(host.Addresses) != 2 {
+		t.Errorf("Expected hostname document, got %+v %v", host, err)
+	}
+
+	table := strings.Split(strings.TrimSpace(print(lookupOptions{format: "table"})), "\n")
+	if len(table) != 4 || !strings.HasPrefix(table[0], "QUERY") || !strings.HasPrefix(table[3], "example.com") ||
+		!strings.Contains(table[1], "AS12345") || !strings.Contains(table[1], "Test ISP") {
+		t.Errorf("Expected header and three rows, got %q", table)
+	}
+
+	if got := print(lookupOptions{format: "json", field: "country_code"}); got != "TS\nTS\nTS\n" {
+		t.Errorf("Expected one value per address, got %q", got)
+	}
+}
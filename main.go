@@ -1,16 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -21,18 +24,36 @@ import (
 // Define a function type for geoip2.Open to make it mockable in tests
 type openFunc func(string) (Reader, error)
 
-// Default implementation uses the real geoip2.Open
-var geoipOpen openFunc = func(filename string) (Reader, error) {
-	return geoip2.Open(filename)
-}
+// Default implementation opens the database with geoip2, adding network
+// walks for range lookups
+var geoipOpen openFunc = openMMDB
 
 // Config represents the application configuration
 type Config struct {
-	Host string `json:"host"`
-	Port string `json:"port"`
-	SSL  bool   `json:"ssl"`   // Whether to use SSL
-	Cert string `json:"cert"`  // Path to certificate file
-	Key  string `json:"key"`   // Path to key file
+	Host             string                      `json:"host"`
+	Port             string                      `json:"port"`
+	SSL              bool                        `json:"ssl"`                       // Whether to use SSL
+	Cert             string                      `json:"cert"`                      // Path to certificate file
+	Key              string                      `json:"key"`                       // Path to key file
+	DBDir            string                      `json:"db_dir"`                    // Directory holding the MaxMind databases
+	Offline          bool                        `json:"offline"`                   // Never download; only use databases already in db_dir
+	UpdateInterval   Duration                    `json:"update_interval"`           // How often to check whether databases need updating
+	UpdateMaxAge     Duration                    `json:"update_max_age"`            // Age after which a database is downloaded again
+	Databases        DatabaseSources             `json:"databases"`                 // Database sources by name
+	DNS              DNSConfig                   `json:"dns"`                       // DNS lookups for reverse DNS
+	Log              LogConfig                   `json:"log"`                       // Logging configuration
+	CloudRanges      map[string]CloudRangeSource `json:"cloud_ranges"`              // Published cloud and CDN range files by provider
+	Lists            map[string]ListSource       `json:"lists"`                     // IP lists by name, reported in IPInfo.Lists
+	OverridesFile    string                      `json:"overrides_file"`            // CIDR overrides merged over database results (CSV, JSON or YAML)
+	PoliciesFile     string                      `json:"policies_file"`             // Named allow/deny policies for /policy/ (JSON or YAML)
+	ForwardAuth      ForwardAuthConfig           `json:"forward_auth"`              // Reverse proxy auth endpoint /auth
+	ExtAuthz         ExtAuthzConfig              `json:"ext_authz"`                 // Envoy ext_authz gRPC server
+	DNSServer        DNSServerConfig             `json:"dns_server"`                // DNS listener answering geo TXT queries
+	AdminToken       string                      `json:"admin_token" secret:"true"` // Bearer token for the /admin/ API; empty disables it
+	TrustedProxies   []string                    `json:"trusted_proxies"`           // Addresses or CIDRs of proxies whose client IP header is believed
+	ClientIPHeader   string                      `json:"client_ip_header"`          // Header the trusted proxies put the client address in
+	RangeMaxPrefix   RangeMaxPrefix              `json:"range_max_prefix"`          // Largest prefixes accepted by /ipgeo/range/{cidr}
+	RangeMaxNetworks int                         `json:"range_max_networks"`        // Most database networks /ipgeo/range/{cidr} collects; 0 means no limit
 }
 
 // Default configuration values
@@ -42,29 +63,169 @@ var defaultConfig = Config{
 	SSL:  false,  // Default to not using SSL
 	Cert: "",     // Empty means no certificate file
 	Key:  "",     // Empty means no key file
+
+	DBDir:            "./maxmind_db",
+	UpdateInterval:   Duration(24 * time.Hour),      // Check daily
+	UpdateMaxAge:     Duration(30 * 24 * time.Hour), // Update monthly
+	Databases:        defaultDatabaseSources,
+	DNS:              defaultDNSConfig,
+	Log:              defaultLogConfig,
+	RangeMaxPrefix:   defaultRangeMaxPrefix,
+	RangeMaxNetworks: defaultRangeMaxNetworks,
+	ClientIPHeader:   defaultClientIPHeader,
+	ExtAuthz:         defaultExtAuthzConfig,
+	DNSServer:        defaultDNSServerConfig,
 }
 
 // IPInfo represents the information about an IP address
 type IPInfo struct {
-	IP                string  `json:"ip"`
-	Network           string  `json:"network"`
-	Version           string  `json:"version"`
-	City              string  `json:"city"`
-	Region            string  `json:"region"`
-	RegionCode        string  `json:"region_code"`
-	Country           string  `json:"country"`
-	CountryName       string  `json:"country_name"`
-	CountryCode       string  `json:"country_code"`
-	CountryCodeISO3   string  `json:"country_code_iso3"`
-	ContinentCode     string  `json:"continent_code"`
-	InEU              bool    `json:"in_eu"`
-	Postal            string  `json:"postal"`
-	Latitude          float64 `json:"latitude"`
-	Longitude         float64 `json:"longitude"`
-	Timezone          string  `json:"timezone"`
-	UTCOffset         string  `json:"utc_offset"`
-	ASN               string  `json:"asn"`
-	Org               string  `json:"org"`
+	IP                     string   `json:"ip"`
+	Hostname               string   `json:"hostname"`
+	Network                string   `json:"network"`
+	Version                string   `json:"version"`
+	AddressType            string   `json:"address_type"`
+	IsPublic               bool     `json:"is_public"`
+	City                   string   `json:"city"`
+	Region                 string   `json:"region"`
+	RegionCode             string   `json:"region_code"`
+	Country                string   `json:"country"`
+	CountryName            string   `json:"country_name"`
+	CountryCode            string   `json:"country_code"`
+	CountryCodeISO3        string   `json:"country_code_iso3"`
+	ContinentCode          string   `json:"continent_code"`
+	InEU                   bool     `json:"in_eu"`
+	RegisteredCountryCode  string   `json:"registered_country_code"`
+	RegisteredCountryName  string   `json:"registered_country_name"`
+	RepresentedCountryCode string   `json:"represented_country_code"`
+	RepresentedCountryName string   `json:"represented_country_name"`
+	RepresentedCountryType string   `json:"represented_country_type"`
+	IsAnycastOrSatellite   bool     `json:"is_anycast_or_satellite"`
+	CountryCodeNumeric     string   `json:"country_code_numeric"`
+	CountryFlag            string   `json:"country_flag"`
+	Capital                string   `json:"capital"`
+	CurrencyCode           string   `json:"currency_code"`
+	CurrencyName           string   `json:"currency_name"`
+	CallingCode            string   `json:"calling_code"`
+	Languages              []string `json:"languages"`
+	TLD                    string   `json:"tld"`
+	AreaKm2                float64  `json:"area_km2"`
+	Population             int64    `json:"population"`
+	Postal                 string   `json:"postal"`
+	Latitude               float64  `json:"latitude"`
+	Longitude              float64  `json:"longitude"`
+	AccuracyRadius         uint16   `json:"accuracy_radius"`
+	MetroCode              uint     `json:"metro_code"`
+	Timezone               string   `json:"timezone"`
+	UTCOffset              string   `json:"utc_offset"`
+	IsDST                  bool     `json:"is_dst"`
+	TimezoneAbbreviation   string   `json:"timezone_abbreviation"`
+	LocalTime              string   `json:"local_time"`
+	ASN                    string   `json:"asn"`
+	Org                    string   `json:"org"`
+
+	// GeoNames IDs of the places above
+	CityGeoNameID               uint `json:"city_geoname_id"`
+	RegionGeoNameID             uint `json:"region_geoname_id"`
+	CountryGeoNameID            uint `json:"country_geoname_id"`
+	ContinentGeoNameID          uint `json:"continent_geoname_id"`
+	RegisteredCountryGeoNameID  uint `json:"registered_country_geoname_id"`
+	RepresentedCountryGeoNameID uint `json:"represented_country_geoname_id"`
+
+	// Fields from the optional GeoIP2 databases
+	IsAnonymous       bool   `json:"is_anonymous"`
+	IsVPN             bool   `json:"is_vpn"`
+	IsHostingProvider bool   `json:"is_hosting_provider"`
+	IsTorExitNode     bool   `json:"is_tor_exit_node"`
+	ISP               string `json:"isp"`
+	ConnectionType    string `json:"connection_type"`
+	Domain            string `json:"domain"`
+
+	// Fields from the cloud provider range files
+	CloudProvider string `json:"cloud_provider"`
+	CloudService  string `json:"cloud_service"`
+	CloudRegion   string `json:"cloud_region"`
+
+	// Names of the configured IP lists containing the address
+	Lists []string `json:"lists"`
+
+	// Labels from the matching override
+	Tags []string `json:"tags,omitempty"`
+
+	// JSON keys left out because the database providing them is disabled
+	omit map[string]bool
+}
+
+// JSON keys of IPInfo provided by each database, and by the cloud range files
+var databaseFields = map[string][]string{
+	"asn": {"asn", "org"},
+	"city": {"city", "region", "region_code", "postal", "latitude", "longitude", "accuracy_radius", "metro_code",
+		"timezone", "utc_offset", "is_dst", "timezone_abbreviation", "local_time", "city_geoname_id", "region_geoname_id"},
+	"country": {"country", "country_name", "country_code", "country_code_iso3", "continent_code", "in_eu",
+		"registered_country_code", "registered_country_name", "represented_country_code", "represented_country_name",
+		"represented_country_type", "is_anycast_or_satellite", "country_code_numeric", "country_flag", "capital",
+		"currency_code", "currency_name", "calling_code", "languages", "tld", "area_km2", "population",
+		"country_geoname_id", "continent_geoname_id",
+		"registered_country_geoname_id", "represented_country_geoname_id"},
+
+	"anonymous_ip":    {"is_anonymous", "is_vpn", "is_hosting_provider", "is_tor_exit_node"},
+	"isp":             {"isp"},
+	"connection_type": {"connection_type"},
+	"domain":          {"domain"},
+
+	"cloud": {"cloud_provider", "cloud_service", "cloud_region"},
+	"lists": {"lists"},
+	"rdns":  {"hostname"},
+}
+
+// omitDatabase leaves the fields of a database out of the JSON output
+func (info *IPInfo) omitDatabase(name string) {
+	if info.omit == nil {
+		info.omit = make(map[string]bool)
+	}
+	for _, key := range databaseFields[name] {
+		info.omit[key] = true
+	}
+}
+
+// MarshalJSON encodes the IP information, leaving out fields of databases
+// that are disabled
+func (info IPInfo) MarshalJSON() ([]byte, error) {
+	type plain IPInfo
+	data, err := json.Marshal(plain(info))
+	if err != nil || len(info.omit) == 0 {
+		return data, err
+	}
+
+	// Copy the object key by key to keep the field order
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		key := token.(string)
+		if info.omit[key] {
+			continue
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
 }
 
 // Reader interface provides a common interface for GeoIP functionality
@@ -72,6 +233,10 @@ type Reader interface {
 	ASN(net.IP) (*geoip2.ASN, error)
 	City(net.IP) (*geoip2.City, error)
 	Country(net.IP) (*geoip2.Country, error)
+	AnonymousIP(net.IP) (*geoip2.AnonymousIP, error)
+	ISP(net.IP) (*geoip2.ISP, error)
+	ConnectionType(net.IP) (*geoip2.ConnectionType, error)
+	Domain(net.IP) (*geoip2.Domain, error)
 	Close() error
 }
 
@@ -80,125 +245,158 @@ type dbConfig struct {
 	reader     Reader
 	url        string
 	localPath  string
+	headers    map[string]string
+	optional   bool
 	lastUpdate time.Time
 	mutex      sync.RWMutex
 }
 
 // Application configuration
 var (
-	config     Config
-	configPath string
+	config          Config
+	configPath      string
+	noCreateConfig  bool
+	printConfigOnly bool
 )
 
 // Database readers and configuration
 var (
-	// Fixed path for MaxMind databases
-	dbDir = "./maxmind_db"
-
-	// Database configurations
-	databases = map[string]*dbConfig{
-		"asn": {
-			url:       "https://git.io/GeoLite2-ASN.mmdb",
-			localPath: filepath.Join(dbDir, "GeoLite2-ASN.mmdb"),
-		},
-		"city": {
-			url:       "https://git.io/GeoLite2-City.mmdb",
-			localPath: filepath.Join(dbDir, "GeoLite2-City.mmdb"),
-		},
-		"country": {
-			url:       "https://git.io/GeoLite2-Country.mmdb",
-			localPath: filepath.Join(dbDir, "GeoLite2-Country.mmdb"),
-		},
-	}
-
-	// ISO3 country codes mapping
-	iso3Codes = map[string]string{
-		"AD": "AND", "AE": "ARE", "AF": "AFG", "AG": "ATG", "AI": "AIA", "AL": "ALB", "AM": "ARM",
-		"AO": "AGO", "AQ": "ATA", "AR": "ARG", "AS": "ASM", "AT": "AUT", "AU": "AUS", "AW": "ABW",
-		"AX": "ALA", "AZ": "AZE", "BA": "BIH", "BB": "BRB", "BD": "BGD", "BE": "BEL", "BF": "BFA",
-		"BG": "BGR", "BH": "BHR", "BI": "BDI", "BJ": "BEN", "BL": "BLM", "BM": "BMU", "BN": "BRN",
-		"BO": "BOL", "BQ": "BES", "BR": "BRA", "BS": "BHS", "BT": "BTN", "BV": "BVT", "BW": "BWA",
-		"BY": "BLR", "BZ": "BLZ", "CA": "CAN", "CC": "CCK", "CD": "COD", "CF": "CAF", "CG": "COG",
-		"CH": "CHE", "CI": "CIV", "CK": "COK", "CL": "CHL", "CM": "CMR", "CN": "CHN", "CO": "COL",
-		"CR": "CRI", "CU": "CUB", "CV": "CPV", "CW": "CUW", "CX": "CXR", "CY": "CYP", "CZ": "CZE",
-		"DE": "DEU", "DJ": "DJI", "DK": "DNK", "DM": "DMA", "DO": "DOM", "DZ": "DZA", "EC": "ECU",
-		"EE": "EST", "EG": "EGY", "EH": "ESH", "ER": "ERI", "ES": "ESP", "ET": "ETH", "FI": "FIN",
-		"FJ": "FJI", "FK": "FLK", "FM": "FSM", "FO": "FRO", "FR": "FRA", "GA": "GAB", "GB": "GBR",
-		"GD": "GRD", "GE": "GEO", "GF": "GUF", "GG": "GGY", "GH": "GHA", "GI": "GIB", "GL": "GRL",
-		"GM": "GMB", "GN": "GIN", "GP": "GLP", "GQ": "GNQ", "GR": "GRC", "GS": "SGS", "GT": "GTM",
-		"GU": "GUM", "GW": "GNB", "GY": "GUY", "HK": "HKG", "HM": "HMD", "HN": "HND", "HR": "HRV",
-		"HT": "HTI", "HU": "HUN", "ID": "IDN", "IE": "IRL", "IL": "ISR", "IM": "IMN", "IN": "IND",
-		"IO": "IOT", "IQ": "IRQ", "IR": "IRN", "IS": "ISL", "IT": "ITA", "JE": "JEY", "JM": "JAM",
-		"JO": "JOR", "JP": "JPN", "KE": "KEN", "KG": "KGZ", "KH": "KHM", "KI": "KIR", "KM": "COM",
-		"KN": "KNA", "KP": "PRK", "KR": "KOR", "KW": "KWT", "KY": "CYM", "KZ": "KAZ", "LA": "LAO",
-		"LB": "LBN", "LC": "LCA", "LI": "LIE", "LK": "LKA", "LR": "LBR", "LS": "LSO", "LT": "LTU",
-		"LU": "LUX", "LV": "LVA", "LY": "LBY", "MA": "MAR", "MC": "MCO", "MD": "MDA", "ME": "MNE",
-		"MF": "MAF", "MG": "MDG", "MH": "MHL", "MK": "MKD", "ML": "MLI", "MM": "MMR", "MN": "MNG",
-		"MO": "MAC", "MP": "MNP", "MQ": "MTQ", "MR": "MRT", "MS": "MSR", "MT": "MLT", "MU": "MUS",
-		"MV": "MDV", "MW": "MWI", "MX": "MEX", "MY": "MYS", "MZ": "MOZ", "NA": "NAM", "NC": "NCL",
-		"NE": "NER", "NF": "NFK", "NG": "NGA", "NI": "NIC", "NL": "NLD", "NO": "NOR", "NP": "NPL",
-		"NR": "NRU", "NU": "NIU", "NZ": "NZL", "OM": "OMN", "PA": "PAN", "PE": "PER", "PF": "PYF",
-		"PG": "PNG", "PH": "PHL", "PK": "PAK", "PL": "POL", "PM": "SPM", "PN": "PCN", "PR": "PRI",
-		"PS": "PSE", "PT": "PRT", "PW": "PLW", "PY": "PRY", "QA": "QAT", "RE": "REU", "RO": "ROU",
-		"RS": "SRB", "RU": "RUS", "RW": "RWA", "SA": "SAU", "SB": "SLB", "SC": "SYC", "SD": "SDN",
-		"SE": "SWE", "SG": "SGP", "SH": "SHN", "SI": "SVN", "SJ": "SJM", "SK": "SVK", "SL": "SLE",
-		"SM": "SMR", "SN": "SEN", "SO": "SOM", "SR": "SUR", "SS": "SSD", "ST": "STP", "SV": "SLV",
-		"SX": "SXM", "SY": "SYR", "SZ": "SWZ", "TC": "TCA", "TD": "TCD", "TF": "ATF", "TG": "TGO",
-		"TH": "THA", "TJ": "TJK", "TK": "TKL", "TL": "TLS", "TM": "TKM", "TN": "TUN", "TO": "TON",
-		"TR": "TUR", "TT": "TTO", "TV": "TUV", "TW": "TWN", "TZ": "TZA", "UA": "UKR", "UG": "UGA",
-		"UM": "UMI", "US": "USA", "UY": "URY", "UZ": "UZB", "VA": "VAT", "VC": "VCT", "VE": "VEN",
-		"VG": "VGB", "VI": "VIR", "VN": "VNM", "VU": "VUT", "WF": "WLF", "WS": "WSM", "YE": "YEM",
-		"YT": "MYT", "ZA": "ZAF", "ZM": "ZMB", "ZW": "ZWE",
-	}
+	// Directory holding the MaxMind databases
+	dbDir = defaultConfig.DBDir
+
+	// Database configurations, rebuilt from Config.Databases at startup
+	databases = buildDatabases(dbDir, defaultDatabaseSources)
 )
 
 func init() {
 	// Define command line flags
-	flag.StringVar(&configPath, "config", "config.json", "Path to configuration file")
+	defaultConfigPath := "config.json"
+	if path, ok := os.LookupEnv(envPrefix + "CONFIG"); ok {
+		defaultConfigPath = path
+	}
+	_, skipCreate := os.LookupEnv(envPrefix + "NO_CREATE_CONFIG")
+
+	flag.StringVar(&configPath, "config", defaultConfigPath, "Path to configuration file (env GEOIP_API_CONFIG)")
+	flag.BoolVar(&noCreateConfig, "no-create-config", skipCreate, "Don't create the configuration file if it doesn't exist (env GEOIP_API_NO_CREATE_CONFIG)")
+	flag.BoolVar(&printConfigOnly, "print-config", false, "Print the effective configuration with secrets redacted and exit")
+
+	// Every configuration value can also be set with a flag
+	registerConfigFlags(flag.CommandLine)
+}
+
+// Subcommands, selected by the first command line argument
+var commands = map[string]func(args []string) int{
+	"validate-config": runValidateConfig,
+	"enrich":          runEnrich,
+	"lookup":          runLookup,
 }
 
 func main() {
+	// Run a subcommand instead of the server if one was given
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
+
 	// Parse command line flags
 	flag.Parse()
 
-	// Check if config.json exists, create it if it doesn't
-	if err := ensureConfigFileExists(configPath); err != nil {
-		log.Printf("Error creating configuration file: %v", err)
+	// Load configuration from defaults, file, environment and flags
+	if err := loadEffectiveConfig(!noCreateConfig && !printConfigOnly, false); err != nil {
+		fatal("Error loading configuration", "error", err)
 	}
 
-	// Load configuration
-	if err := loadConfig(configPath); err != nil {
-		log.Printf("Error loading configuration: %v", err)
-		log.Println("Using default configuration")
+	if printConfigOnly {
+		if err := printConfig(os.Stdout, config); err != nil {
+			fatal("Failed to print configuration", "error", err)
+		}
+		return
+	}
 
-		// Set default configuration
-		config = defaultConfig
+	// Validate the configuration, reporting all problems at once
+	if err := validateConfig(config); err != nil {
+		fatal("Invalid configuration", "error", err)
 	}
 
-	// Validate SSL configuration
-	if err := validateSSLConfig(); err != nil {
-		log.Fatalf("Invalid SSL configuration: %v", err)
+	// Configure logging as early as possible
+	if err := setupLogging(config.Log); err != nil {
+		fatal("Invalid logging configuration", "error", err)
+	}
+	setupDNS(config.DNS)
+	if err := setupTrustedProxies(config); err != nil {
+		fatal("Invalid trusted proxies", "error", err)
 	}
 
-	// Ensure database directory exists
-	if err := os.MkdirAll(dbDir, 0755); err != nil {
-		log.Fatalf("Failed to create database directory: %v", err)
+	// Ensure database directory exists. In offline mode it is provisioned
+	// externally and may be read-only.
+	configureDatabases(config)
+	if !config.Offline {
+		if err := os.MkdirAll(dbDir, 0755); err != nil {
+			fatal("Failed to create database directory", "error", err)
+		}
 	}
 
 	// Initialize or update databases
 	if err := initDatabases(); err != nil {
-		log.Fatalf("Error initializing databases: %v", err)
+		fatal("Error initializing databases", "error", err)
 	}
 
-	// Start a goroutine to periodically update databases
-	go startDatabaseUpdater()
+	// Load local overrides, reloaded on SIGHUP and through the admin API
+	if err := reloadOverrides(); err != nil {
+		fatal("Error loading overrides", "error", err)
+	}
+
+	// Load policies, reloaded along with the overrides
+	if err := reloadPolicies(); err != nil {
+		fatal("Error loading policies", "error", err)
+	}
+	if err := setupForwardAuth(config.ForwardAuth); err != nil {
+		fatal("Error configuring forward auth", "error", err)
+	}
+	go watchReloadSignal()
+
+	// Load cloud provider ranges, refreshed along with the databases
+	if err := initCloudRanges(); err != nil {
+		fatal("Error loading cloud ranges", "error", err)
+	}
+
+	// Load IP lists, each refreshed on its own interval
+	configureLists(config)
+	if err := initLists(); err != nil {
+		fatal("Error loading IP lists", "error", err)
+	}
+	startListUpdaters()
+
+	if config.Offline {
+		// Pick up databases replaced by an external process
+		go func() {
+			if err := watchDatabaseDir(nil); err != nil {
+				logger.Error("Failed to watch database directory", "dir", dbDir, "error", err)
+			}
+		}()
+	} else {
+		// Start a goroutine to periodically update databases
+		go startDatabaseUpdater()
+	}
+
+	if config.ExtAuthz.Enabled {
+		if err := startExtAuthz(); err != nil {
+			fatal("Error starting ext_authz server", "error", err)
+		}
+	}
+	if config.DNSServer.Enabled {
+		if err := startDNSServer(); err != nil {
+			fatal("Error starting DNS server", "error", err)
+		}
+	}
 
 	// Set up router with custom handler that checks all requests
-	http.HandleFunc("/", handleRequest)
+	http.Handle("/", withAccessLog(http.HandlerFunc(handleRequest)))
 
 	// Start the server
 	addr := fmt.Sprintf("%s:%s", config.Host, config.Port)
-	log.Printf("Starting server on %s...\n", addr)
+	logger.Info("Starting server", "addr", addr)
 
 	if config.SSL {
 		// Ensure we have certificate and key files
@@ -206,21 +404,79 @@ func main() {
 			// Generate self-signed certificates
 			certFile, keyFile, err := generateSelfSignedCert()
 			if err != nil {
-				log.Fatalf("Failed to generate self-signed certificate: %v", err)
+				fatal("Failed to generate self-signed certificate", "error", err)
 			}
 			config.Cert = certFile
 			config.Key = keyFile
-			log.Printf("Using self-signed certificate: %s and key: %s", config.Cert, config.Key)
+			logger.Info("Using self-signed certificate", "cert", config.Cert, "key", config.Key)
 		} else {
-			log.Printf("Using provided certificate: %s and key: %s", config.Cert, config.Key)
+			logger.Info("Using provided certificate", "cert", config.Cert, "key", config.Key)
 		}
 
-		log.Fatal(http.ListenAndServeTLS(addr, config.Cert, config.Key, nil))
+		fatal("Server stopped", "error", http.ListenAndServeTLS(addr, config.Cert, config.Key, nil))
 	} else {
-		log.Fatal(http.ListenAndServe(addr, nil))
+		fatal("Server stopped", "error", http.ListenAndServe(addr, nil))
 	}
 }
 
+// loadEffectiveConfig builds the configuration from the defaults, the
+// configuration file, environment variables and command line flags, in
+// increasing order of precedence
+func loadEffectiveConfig(createFile bool, requireFile bool) error {
+	// Check if config.json exists, create it if it doesn't
+	if createFile {
+		if err := ensureConfigFileExists(configPath); err != nil {
+			logger.Error("Error creating configuration file", "error", err)
+		}
+	}
+
+	config = defaultConfig
+	var fileErr *ConfigError
+	if err := loadConfig(configPath); os.IsNotExist(err) && !requireFile {
+		logger.Info("Configuration file not found, using default configuration", "path", configPath)
+	} else if err != nil && !errors.As(err, &fileErr) {
+		return err
+	}
+
+	// Environment variables override the file, flags override both
+	if err := applyEnvOverrides(&config, lookupEnv); err != nil {
+		return err
+	}
+	if err := applyFlagOverrides(&config, flag.CommandLine); err != nil {
+		return err
+	}
+
+	// Report the file's problems together with those of the values that
+	// could be read
+	if fileErr != nil {
+		var validateErr *ConfigError
+		if errors.As(validateConfig(config), &validateErr) {
+			fileErr.Problems = append(fileErr.Problems, validateErr.Problems...)
+		}
+		return fileErr
+	}
+	return nil
+}
+
+// runValidateConfig implements the validate-config subcommand
+func runValidateConfig(args []string) int {
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return 2
+	}
+
+	if err := loadEffectiveConfig(false, true); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := validateConfig(config); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("Configuration %s is valid\n", configPath)
+	return 0
+}
+
 // Ensure the configuration file exists, create with default values if it doesn't
 func ensureConfigFileExists(path string) error {
 	// Ensure parent directory exists
@@ -234,7 +490,7 @@ func ensureConfigFileExists(path string) error {
 	// Check if file exists
 	_, err := os.Stat(path)
 	if os.IsNotExist(err) {
-		log.Printf("Configuration file %s does not exist, creating with default values", path)
+		logger.Info("Configuration file does not exist, creating with default values", "path", path)
 
 		// Create the file with default config
 		data, err := json.MarshalIndent(defaultConfig, "", "  ")
@@ -247,7 +503,7 @@ func ensureConfigFileExists(path string) error {
 			return fmt.Errorf("failed to write default configuration file: %v", err)
 		}
 
-		log.Printf("Created default configuration file at %s", path)
+		logger.Info("Created default configuration file", "path", path)
 	} else if err != nil {
 		return fmt.Errorf("failed to check if config file exists: %v", err)
 	}
@@ -268,17 +524,30 @@ func loadConfig(path string) error {
 		return err
 	}
 
-	if err := json.Unmarshal(data, &config); err != nil {
+	if !json.Valid(data) {
+		var value any
+		return fmt.Errorf("failed to parse %s: %v", path, json.Unmarshal(data, &value))
+	}
+
+	// Reject keys that don't correspond to a configuration field
+	v := &configValidator{}
+	for _, key := range unknownConfigKeys(data, reflect.TypeOf(Config{}), "") {
+		v.addf(key, "unknown field")
+	}
+
+	// Start from the defaults so that missing keys keep their default value.
+	// Fields are decoded one by one so that every bad value is reported.
+	loaded := defaultConfig
+	decodeConfig(data, reflect.ValueOf(&loaded).Elem(), "", v)
+	config = loaded
+	if err := v.err(); err != nil {
 		return err
 	}
 
-	log.Printf("Configuration loaded from %s", path)
-	log.Printf("  Host: %s", config.Host)
-	log.Printf("  Port: %s", config.Port)
-	log.Printf("  SSL: %v", config.SSL)
+	logger.Info("Configuration loaded", "path", path,
+		"host", config.Host, "port", config.Port, "ssl", config.SSL)
 	if config.SSL {
-		log.Printf("  Certificate: %s", config.Cert)
-		log.Printf("  Key: %s", config.Key)
+		logger.Info("SSL configuration", "cert", config.Cert, "key", config.Key)
 	}
 
 	return nil
@@ -286,12 +555,31 @@ func loadConfig(path string) error {
 
 // Initialize databases - download if needed and open readers
 func initDatabases() error {
+	// The indexes are built once every database is open
+	defer rebuildStaleIndexes()
+
+	var missing []string
 	for name, db := range databases {
 		// Check if database file exists
 		if _, err := os.Stat(db.localPath); os.IsNotExist(err) {
+			// Optional databases are only loaded when their file is provided
+			if db.optional && (config.Offline || db.url == "") {
+				logger.Info("Optional database not found, skipping", "database", name, "path", db.localPath)
+				continue
+			}
+
+			// In offline mode nothing is downloaded, collect all missing files
+			if config.Offline {
+				missing = append(missing, fmt.Sprintf("%s (%s)", name, db.localPath))
+				continue
+			}
+
 			// Database file doesn't exist, download it
-			log.Printf("Database %s not found, downloading...", name)
-			if err := downloadDatabase(db.url, db.localPath); err != nil {
+			if db.url == "" {
+				return fmt.Errorf("%s database %s not found and no download URL configured", name, db.localPath)
+			}
+			logger.Info("Database not found, downloading", "database", name)
+			if err := downloadDatabase(db.url, db.localPath, db.headers); err != nil {
 				return fmt.Errorf("failed to download %s database: %v", name, err)
 			}
 			db.lastUpdate = time.Now()
@@ -303,8 +591,9 @@ func initDatabases() error {
 			return fmt.Errorf("error opening %s database: %v", name, err)
 		}
 
-		log.Printf("Successfully opened %s database", name)
+		logger.Info("Successfully opened database", "database", name)
 		db.reader = reader
+		markIndexesStale(name)
 
 		// If we don't know when it was last updated, set to file's modification time
 		if db.lastUpdate.IsZero() {
@@ -317,33 +606,53 @@ func initDatabases() error {
 		}
 	}
 
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("offline mode: missing database files: %s", strings.Join(missing, ", "))
+	}
+
 	return nil
 }
 
 // Start a goroutine that periodically updates the databases
 func startDatabaseUpdater() {
-	ticker := time.NewTicker(24 * time.Hour) // Check daily
+	interval := time.Duration(config.UpdateInterval)
+	if interval <= 0 {
+		interval = time.Duration(defaultConfig.UpdateInterval)
+	}
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
 			updateDatabasesIfNeeded()
+			refreshCloudRanges()
 		}
 	}
 }
 
 // Check if databases need updating and update them if needed
 func updateDatabasesIfNeeded() {
+	maxAge := time.Duration(config.UpdateMaxAge)
+	if maxAge <= 0 {
+		maxAge = time.Duration(defaultConfig.UpdateMaxAge)
+	}
+
 	for name, db := range databases {
-		// Check if database is older than one month
-		if time.Since(db.lastUpdate) >= 30*24*time.Hour {
-			log.Printf("Database %s is older than 30 days, updating...", name)
+		// Databases without a download URL are managed externally
+		if db.url == "" {
+			continue
+		}
+
+		// Check if database is older than the maximum age
+		if time.Since(db.lastUpdate) >= maxAge {
+			logger.Info("Database is outdated, updating", "database", name, "max_age", maxAge)
 
 			// Download to a temporary file
 			tempPath := db.localPath + ".new"
-			if err := downloadDatabase(db.url, tempPath); err != nil {
-				log.Printf("Failed to download updated %s database: %v", name, err)
+			if err := downloadDatabase(db.url, tempPath, db.headers); err != nil {
+				logger.Error("Failed to download updated database", "database", name, "error", err)
 				continue
 			}
 
@@ -355,9 +664,9 @@ func updateDatabasesIfNeeded() {
 
 			// Replace the old file with the new one
 			if err := os.Rename(tempPath, db.localPath); err != nil {
-				log.Printf("Failed to replace %s database file: %v", name, err)
+				logger.Error("Failed to replace database file", "database", name, "error", err)
 				// Try to reopen the old file
-				if reader, err := geoip2.Open(db.localPath); err == nil {
+				if reader, err := geoipOpen(db.localPath); err == nil {
 					db.reader = reader
 				}
 				db.mutex.Unlock()
@@ -367,7 +676,7 @@ func updateDatabasesIfNeeded() {
 			// Open the new database
 			reader, err := geoipOpen(db.localPath)
 			if err != nil {
-				log.Printf("Failed to open updated %s database: %v", name, err)
+				logger.Error("Failed to open updated database", "database", name, "error", err)
 				db.mutex.Unlock()
 				continue
 			}
@@ -377,22 +686,29 @@ func updateDatabasesIfNeeded() {
 			db.lastUpdate = time.Now()
 			db.mutex.Unlock()
 
-			log.Printf("Successfully updated %s database", name)
+			logger.Info("Successfully updated database", "database", name)
+			markIndexesStale(name)
 		}
 	}
+	rebuildStaleIndexes()
 }
 
 // Download a file from the specified URL to the local path
-func downloadDatabase(url string, localPath string) error {
+func downloadDatabase(url string, localPath string, headers map[string]string) (err error) {
 	// Create a temporary file
 	out, err := os.Create(localPath)
 	if err != nil {
 		return err
 	}
+	defer removeOnError(localPath, &err)
 	defer out.Close()
 
 	// Send HTTP GET request
-	resp, err := http.Get(url)
+	req, err := newDownloadRequest(url, headers)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -404,12 +720,12 @@ func downloadDatabase(url string, localPath string) error {
 	}
 
 	// Copy the file content
-	_, err = io.Copy(out, resp.Body)
-	return err
+	return writeDatabase(out, resp.Body)
 }
 
 func handleRequest(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Path
+	reqLogger := requestLogger(r)
 
 	// Check host if configured
 	if config.Host != "" {
@@ -421,45 +737,84 @@ func handleRequest(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if requestHost != config.Host {
-			log.Printf("Request rejected due to incorrect host: %s (expected %s)", requestHost, config.Host)
+			reqLogger.Debug("Request rejected due to incorrect host", "host", requestHost, "expected", config.Host)
 			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
 	}
 
 	// Log the request
-	log.Printf("Request received: %s %s from %s", r.Method, path, getClientIP(r))
+	reqLogger.Debug("Request received", "method", r.Method, "path", maskPath(path), "client_ip", maskIP(getClientIP(r)))
 
 	// Check if path is one of our valid endpoints
 	if path == "/ipgeo" {
 		// Handle client IP lookup
 		clientIP := getClientIP(r)
-		log.Printf("Processing request for client IP: %s", clientIP)
 		handleIPLookup(w, r, clientIP)
 		return
+	} else if cidr, ok := strings.CutPrefix(path, "/ipgeo/range/"); ok {
+		handleRangeLookup(w, r, cidr)
+		return
 	} else if strings.HasPrefix(path, "/ipgeo/") {
 		// Extract IP from the path
 		parts := strings.Split(path, "/")
 		if len(parts) == 3 && parts[1] == "ipgeo" {
 			ipAddress := parts[2]
-			log.Printf("Processing request for specific IP: %s", ipAddress)
+			if net.ParseIP(ipAddress) == nil && isHostname(ipAddress) {
+				handleHostnameLookup(w, r, ipAddress)
+				return
+			}
 			handleIPLookup(w, r, ipAddress)
 			return
 		}
+	} else if strings.HasPrefix(path, "/asn/") {
+		parts := strings.Split(path, "/")
+		if len(parts) == 3 && parts[2] != "" {
+			handleASN(w, r, parts[2])
+			return
+		}
+	} else if path == "/distance" {
+		handleDistance(w, r)
+		return
+	} else if path == "/search" {
+		handleSearch(w, r)
+		return
+	} else if path == "/status" {
+		handleStatus(w, r)
+		return
+	} else if path == "/auth" && config.ForwardAuth.Enabled {
+		handleForwardAuth(w, r)
+		return
+	} else if strings.HasPrefix(path, "/policy/") {
+		handlePolicy(w, r)
+		return
+	} else if path == "/lists" {
+		handleLists(w, r)
+		return
+	} else if strings.HasPrefix(path, "/admin/") {
+		handleAdmin(w, r)
+		return
+	} else if strings.HasPrefix(path, "/countries/") {
+		parts := strings.Split(path, "/")
+		if len(parts) == 3 && parts[2] != "" {
+			handleCountry(w, r, parts[2])
+			return
+		}
 	}
 
 	// All other requests are forbidden
-	log.Printf("Rejecting request with 403 Forbidden: %s", path)
+	reqLogger.Debug("Rejecting request with 403 Forbidden", "path", maskPath(path))
 	http.Error(w, "Forbidden", http.StatusForbidden)
 }
 
 func handleIPLookup(w http.ResponseWriter, r *http.Request, ipAddress string) {
 	w.Header().Set("Content-Type", "application/json")
+	reqLogger := requestLogger(r)
 
 	// Parse IP address
 	ip := net.ParseIP(ipAddress)
 	if ip == nil {
-		log.Printf("Invalid IP address provided: %s", ipAddress)
+		reqLogger.Debug("Invalid IP address provided", "ip", ipAddress)
 		http.Error(w, "Invalid IP address", http.StatusBadRequest)
 		return
 	}
@@ -467,18 +822,22 @@ func handleIPLookup(w http.ResponseWriter, r *http.Request, ipAddress string) {
 	// Get IP information
 	ipInfo, err := getIPInfo(ip)
 	if err != nil {
-		log.Printf("Error getting info for IP %s: %v", ipAddress, err)
+		reqLogger.Error("Error getting IP info", "ip", maskIP(ipAddress), "error", err)
 		http.Error(w, fmt.Sprintf("Error getting IP info: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("Successfully processed IP %s (%s, %s)",
-		ipAddress, ipInfo.CountryName, ipInfo.City)
+	if wantReverseDNS(r) {
+		addHostname(ipInfo, ip)
+	}
+
+	reqLogger.Debug("Successfully processed IP", "ip", maskIP(ipAddress),
+		"country", ipInfo.CountryName, "city", ipInfo.City)
 
 	// Return the JSON response
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(ipInfo); err != nil {
-		log.Printf("Error encoding JSON response for IP %s: %v", ipAddress, err)
+		reqLogger.Error("Error encoding JSON response", "ip", maskIP(ipAddress), "error", err)
 	}
 }
 
@@ -492,71 +851,130 @@ func getIPInfo(ip net.IP) (*IPInfo, error) {
 		info.Version = "IPv6"
 	}
 
+	// The hostname is only looked up on request
+	info.omitDatabase("rdns")
+
+	// Check the IP lists, which may well hold private ranges
+	lookupLists(info, ip)
+
+	// Private and special-purpose addresses aren't in the databases
+	info.AddressType = classifyIP(ip)
+	info.IsPublic = info.AddressType == addressPublic
+	if !info.IsPublic {
+		for name := range databaseFields {
+			if name != "lists" {
+				info.omitDatabase(name)
+			}
+		}
+		return finishIPInfo(info, ip), nil
+	}
+
 	// Get ASN information
-	databases["asn"].mutex.RLock()
-	asn, err := databases["asn"].reader.ASN(ip)
-	databases["asn"].mutex.RUnlock()
+	var asn *geoip2.ASN
+	found, err := readDatabase("asn", func(reader Reader) (err error) {
+		asn, err = reader.ASN(ip)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("ASN lookup error: %v", err)
 	}
+	if found {
+		info.ASN = fmt.Sprintf("AS%d", asn.AutonomousSystemNumber)
+		info.Org = asn.AutonomousSystemOrganization
+	} else {
+		info.omitDatabase("asn")
+	}
 
-	info.ASN = fmt.Sprintf("AS%d", asn.AutonomousSystemNumber)
-	info.Org = asn.AutonomousSystemOrganization
+	// Get anonymizer, ISP, connection type and domain information
+	if err := lookupTraits(info, ip); err != nil {
+		return nil, err
+	}
+
+	// Tag cloud and CDN provider ranges
+	lookupCloud(info, ip)
 
 	// Get city information
-	databases["city"].mutex.RLock()
-	city, err := databases["city"].reader.City(ip)
-	databases["city"].mutex.RUnlock()
+	var city *geoip2.City
+	found, err = readDatabase("city", func(reader Reader) (err error) {
+		city, err = reader.City(ip)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("city lookup error: %v", err)
 	}
-
-	info.City = city.City.Names["en"]
-	if len(city.Subdivisions) > 0 {
-		info.Region = city.Subdivisions[0].Names["en"]
-		info.RegionCode = city.Subdivisions[0].IsoCode
+	if found {
+		info.City = city.City.Names["en"]
+		if len(city.Subdivisions) > 0 {
+			info.Region = city.Subdivisions[0].Names["en"]
+			info.RegionCode = city.Subdivisions[0].IsoCode
+			info.RegionGeoNameID = city.Subdivisions[0].GeoNameID
+		}
+		info.CityGeoNameID = city.City.GeoNameID
+		info.Postal = city.Postal.Code
+		info.Latitude = city.Location.Latitude
+		info.Longitude = city.Location.Longitude
+		info.AccuracyRadius = city.Location.AccuracyRadius
+		info.MetroCode = city.Location.MetroCode
+		info.Timezone = city.Location.TimeZone
+		addTimeInfo(info)
+	} else {
+		info.omitDatabase("city")
 	}
-	info.Postal = city.Postal.Code
-	info.Latitude = city.Location.Latitude
-	info.Longitude = city.Location.Longitude
-	info.Timezone = city.Location.TimeZone
 
 	// Get country information
-	databases["country"].mutex.RLock()
-	country, err := databases["country"].reader.Country(ip)
-	databases["country"].mutex.RUnlock()
+	var country *geoip2.Country
+	found, err = readDatabase("country", func(reader Reader) (err error) {
+		country, err = reader.Country(ip)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("country lookup error: %v", err)
 	}
+	if !found && city != nil {
+		// The city database carries the country as well
+		country = &geoip2.Country{}
+		country.Country = city.Country
+		country.Continent = city.Continent
+		country.RegisteredCountry = city.RegisteredCountry
+		country.RepresentedCountry = city.RepresentedCountry
+		country.Traits = city.Traits
+	}
+	if country == nil {
+		info.omitDatabase("country")
+		return finishIPInfo(info, ip), nil
+	}
 
 	info.Country = country.Country.IsoCode
 	info.CountryName = country.Country.Names["en"]
 	info.CountryCode = country.Country.IsoCode
 	info.ContinentCode = country.Continent.Code
 	info.InEU = country.Country.IsInEuropeanUnion
+	info.CountryGeoNameID = country.Country.GeoNameID
+	info.ContinentGeoNameID = country.Continent.GeoNameID
+
+	// The registered country differs from the location for e.g. corporate
+	// networks or mobile roaming; the represented country is set for
+	// military bases and embassies
+	info.RegisteredCountryCode = country.RegisteredCountry.IsoCode
+	info.RegisteredCountryName = country.RegisteredCountry.Names["en"]
+	info.RegisteredCountryGeoNameID = country.RegisteredCountry.GeoNameID
+	info.RepresentedCountryCode = country.RepresentedCountry.IsoCode
+	info.RepresentedCountryName = country.RepresentedCountry.Names["en"]
+	info.RepresentedCountryType = country.RepresentedCountry.Type
+	info.RepresentedCountryGeoNameID = country.RepresentedCountry.GeoNameID
+
+	// Location data for anycast and satellite networks isn't meaningful
+	info.IsAnycastOrSatellite = country.Traits.IsAnycast || country.Traits.IsSatelliteProvider
+
+	// MaxMind doesn't provide ISO3 codes or other country metadata, so we
+	// populate these from our own data
+	addCountryInfo(info)
+
+	return finishIPInfo(info, ip), nil
+}
 
-	// MaxMind doesn't provide ISO3 codes directly, so we'll have to populate this from our own data
-	if iso3, ok := iso3Codes[info.CountryCode]; ok {
-		info.CountryCodeISO3 = iso3
-	} else {
-		info.CountryCodeISO3 = info.CountryCode // Fallback
-	}
-
-	// Calculate UTC offset based on timezone
-	if info.Timezone != "" {
-		loc, err := time.LoadLocation(info.Timezone)
-		if err == nil {
-			_, offset := time.Now().In(loc).Zone()
-			offsetHours := offset / 3600
-			offsetSign := "+"
-			if offsetHours < 0 {
-				offsetSign = "-"
-				offsetHours = -offsetHours
-			}
-			info.UTCOffset = fmt.Sprintf("%s%02d00", offsetSign, offsetHours)
-		}
-	}
-
+// finishIPInfo fills in the fields that don't depend on any database
+func finishIPInfo(info *IPInfo, ip net.IP) *IPInfo {
 	// Network information isn't directly available in current version
 	// We'll construct a basic network from the IP
 	if ip.To4() != nil {
@@ -569,39 +987,17 @@ func getIPInfo(ip net.IP) (*IPInfo, error) {
 		info.Network = fmt.Sprintf("%s/64", network.String())
 	}
 
-	return info, nil
-}
-
-func getClientIP(r *http.Request) string {
-	// Check for X-Forwarded-For header
-	forwarded := r.Header.Get("X-Forwarded-For")
-	if forwarded != "" {
-		// The first IP in the list is the client IP
-		ips := strings.Split(forwarded, ",")
-		return strings.TrimSpace(ips[0])
-	}
+	// Local knowledge beats the databases
+	applyOverrides(info, ip)
 
-	// Otherwise, use RemoteAddr
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
-	}
-	return ip
+	return info
 }
 
 // validateSSLConfig validates the SSL configuration
 func validateSSLConfig() error {
-	// If SSL is disabled but cert or key is specified, return an error
-	if !config.SSL && (config.Cert != "" || config.Key != "") {
-		return fmt.Errorf("SSL is disabled but certificate or key path is provided")
-	}
-
-	// If SSL is enabled and only one of cert or key is specified, return an error
-	if config.SSL && ((config.Cert != "" && config.Key == "") || (config.Cert == "" && config.Key != "")) {
-		return fmt.Errorf("both certificate and key must be provided when using SSL with custom certificates")
-	}
-
-	return nil
+	v := &configValidator{}
+	v.checkSSL(config)
+	return v.err()
 }
 
 // generateSelfSignedCert generates a self-signed certificate and key
@@ -624,7 +1020,7 @@ func generateSelfSignedCert() (string, string, error) {
 	}
 
 	// Generate a new certificate and key using openssl
-	log.Println("Generating self-signed certificate...")
+	logger.Info("Generating self-signed certificate")
 
 	// Generate private key
 	keyCmd := exec.Command("openssl", "genrsa", "-out", keyFile, "2048")
@@ -640,6 +1036,6 @@ func generateSelfSignedCert() (string, string, error) {
 		return "", "", fmt.Errorf("failed to generate self-signed certificate: %v", err)
 	}
 
-	log.Println("Self-signed certificate generated successfully")
+	logger.Info("Self-signed certificate generated successfully")
 	return certFile, keyFile, nil
 }
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -157,6 +158,7 @@ func TestEnsureConfigFileExists(t *testing.T) {
 }
 
 func TestGetClientIP(t *testing.T) {
+	trustProxies(t, "192.0.2.0/24")
 	tests := []struct {
 		name     string
 		request  *http.Request
@@ -170,7 +172,7 @@ func TestGetClientIP(t *testing.T) {
 		{
 			name:     "Multiple IPs in X-Forwarded-For",
 			request:  httptest.NewRequest(http.MethodGet, "/", nil),
-			expected: "192.168.1.1",
+			expected: "172.16.0.1", // The rightmost address that isn't a trusted proxy
 		},
 		{
 			name:     "Remote address only",
@@ -204,28 +206,25 @@ func TestGetIPInfo(t *testing.T) {
 	databases = map[string]*dbConfig{
 		"asn": {
 			reader: mockReader,
-			mutex:  originalDatabases["asn"].mutex,
 		},
 		"city": {
 			reader: mockReader,
-			mutex:  originalDatabases["city"].mutex,
 		},
 		"country": {
 			reader: mockReader,
-			mutex:  originalDatabases["country"].mutex,
 		},
 	}
 
 	// Test with IPv4
-	ip := net.ParseIP("192.168.1.1")
+	ip := net.ParseIP("81.2.69.142")
 	info, err := getIPInfo(ip)
 	if err != nil {
 		t.Fatalf("Failed to get IP info: %v", err)
 	}
 
 	// Verify IP info
-	if info.IP != "192.168.1.1" {
-		t.Errorf("Expected IP '192.168.1.1', got '%s'", info.IP)
+	if info.IP != "81.2.69.142" {
+		t.Errorf("Expected IP '81.2.69.142', got '%s'", info.IP)
 	}
 	if info.Version != "IPv4" {
 		t.Errorf("Expected Version 'IPv4', got '%s'", info.Version)
@@ -239,6 +238,19 @@ func TestGetIPInfo(t *testing.T) {
 	if info.ASN != "AS12345" {
 		t.Errorf("Expected ASN 'AS12345', got '%s'", info.ASN)
 	}
+	if info.AccuracyRadius != 50 || info.MetroCode != 501 {
+		t.Errorf("Expected accuracy radius 50 and metro code 501, got %d and %d", info.AccuracyRadius, info.MetroCode)
+	}
+	if info.RegisteredCountryCode != "RC" || info.RegisteredCountryName != "Registered Country" {
+		t.Errorf("Expected registered country 'RC', got '%s' (%s)", info.RegisteredCountryCode, info.RegisteredCountryName)
+	}
+	if info.RepresentedCountryCode != "" || info.IsAnycastOrSatellite {
+		t.Errorf("Expected no represented country and no anycast flag, got %+v", info)
+	}
+	geoNameIDs := []uint{info.CityGeoNameID, info.RegionGeoNameID, info.CountryGeoNameID, info.ContinentGeoNameID, info.RegisteredCountryGeoNameID}
+	if !reflect.DeepEqual(geoNameIDs, []uint{1001, 1002, 1003, 1004, 1005}) {
+		t.Errorf("Unexpected GeoName IDs: %v", geoNameIDs)
+	}
 
 	// Test with IPv6
 	ip = net.ParseIP("2001:db8::1")
@@ -262,15 +274,12 @@ func TestHandleIPLookup(t *testing.T) {
 	databases = map[string]*dbConfig{
 		"asn": {
 			reader: mockReader,
-			mutex:  originalDatabases["asn"].mutex,
 		},
 		"city": {
 			reader: mockReader,
-			mutex:  originalDatabases["city"].mutex,
 		},
 		"country": {
 			reader: mockReader,
-			mutex:  originalDatabases["country"].mutex,
 		},
 	}
 
@@ -322,15 +331,12 @@ func TestHandleRequest(t *testing.T) {
 	databases = map[string]*dbConfig{
 		"asn": {
 			reader: mockReader,
-			mutex:  originalDatabases["asn"].mutex,
 		},
 		"city": {
 			reader: mockReader,
-			mutex:  originalDatabases["city"].mutex,
 		},
 		"country": {
 			reader: mockReader,
-			mutex:  originalDatabases["country"].mutex,
 		},
 	}
 
@@ -619,6 +625,7 @@ func TestGenerateSelfSignedCert(t *testing.T) {
 
 // TestGetClientIPEdgeCases tests additional edge cases for the getClientIP function
 func TestGetClientIPEdgeCases(t *testing.T) {
+	trustProxies(t, "192.0.2.0/24")
 	tests := []struct {
 		name     string
 		request  *http.Request
@@ -686,6 +693,22 @@ func (m *ErrorMockReader) Country(ip net.IP) (*geoip2.Country, error) {
 	return nil, fmt.Errorf("mock Country error")
 }
 
+func (m *ErrorMockReader) AnonymousIP(ip net.IP) (*geoip2.AnonymousIP, error) {
+	return nil, fmt.Errorf("mock AnonymousIP error")
+}
+
+func (m *ErrorMockReader) ISP(ip net.IP) (*geoip2.ISP, error) {
+	return nil, fmt.Errorf("mock ISP error")
+}
+
+func (m *ErrorMockReader) ConnectionType(ip net.IP) (*geoip2.ConnectionType, error) {
+	return nil, fmt.Errorf("mock ConnectionType error")
+}
+
+func (m *ErrorMockReader) Domain(ip net.IP) (*geoip2.Domain, error) {
+	return nil, fmt.Errorf("mock Domain error")
+}
+
 func (m *ErrorMockReader) Close() error {
 	return nil
 }
@@ -701,20 +724,17 @@ func TestGetIPInfoErrors(t *testing.T) {
 	databases = map[string]*dbConfig{
 		"asn": {
 			reader: errorReader,
-			mutex:  originalDatabases["asn"].mutex,
 		},
 		"city": {
 			reader: errorReader,
-			mutex:  originalDatabases["city"].mutex,
 		},
 		"country": {
 			reader: errorReader,
-			mutex:  originalDatabases["country"].mutex,
 		},
 	}
 
 	// Test with valid IP but readers that return errors
-	ip := net.ParseIP("192.168.1.1")
+	ip := net.ParseIP("81.2.69.142")
 	_, err := getIPInfo(ip)
 
 	// Should return an error due to city lookup failure
@@ -768,24 +788,21 @@ func TestHandleIPLookupErrors(t *testing.T) {
 	databases = map[string]*dbConfig{
 		"asn": {
 			reader: errorReader,
-			mutex:  originalDatabases["asn"].mutex,
 		},
 		"city": {
 			reader: errorReader,
-			mutex:  originalDatabases["city"].mutex,
 		},
 		"country": {
 			reader: errorReader,
-			mutex:  originalDatabases["country"].mutex,
 		},
 	}
 
 	// Set up request for a valid IP
-	req := httptest.NewRequest(http.MethodGet, "/ipgeo/192.168.1.1", nil)
+	req := httptest.NewRequest(http.MethodGet, "/ipgeo/81.2.69.142", nil)
 	w := httptest.NewRecorder()
 
 	// Call handleIPLookup with a valid IP
-	handleIPLookup(w, req, "192.168.1.1")
+	handleIPLookup(w, req, "81.2.69.142")
 
 	// Check response
 	resp := w.Result()
@@ -839,24 +856,21 @@ func TestHandleIPLookupWriteError(t *testing.T) {
 	databases = map[string]*dbConfig{
 		"asn": {
 			reader: mockReader,
-			mutex:  originalDatabases["asn"].mutex,
 		},
 		"city": {
 			reader: mockReader,
-			mutex:  originalDatabases["city"].mutex,
 		},
 		"country": {
 			reader: mockReader,
-			mutex:  originalDatabases["country"].mutex,
 		},
 	}
 
 	// Set up a normal request but with a response writer that fails
-	req := httptest.NewRequest(http.MethodGet, "/ipgeo/192.168.1.1", nil)
+	req := httptest.NewRequest(http.MethodGet, "/ipgeo/81.2.69.142", nil)
 	w := NewMockErrorWriter()
 
 	// Call handleIPLookup - this should log an error but not panic
-	handleIPLookup(w, req, "192.168.1.1")
+	handleIPLookup(w, req, "81.2.69.142")
 
 	// If we got here without panicking, we're good
 }
\ No newline at end of file
@@ -62,6 +62,7 @@ type MockCity struct {
 	} `maxminddb:"subdivisions"`
 	Traits struct {
 		IsAnonymousProxy    bool `maxminddb:"is_anonymous_proxy"`
+		IsAnycast           bool `maxminddb:"is_anycast"`
 		IsSatelliteProvider bool `maxminddb:"is_satellite_provider"`
 	} `maxminddb:"traits"`
 }
@@ -94,6 +95,7 @@ type MockCountry struct {
 	} `maxminddb:"represented_country"`
 	Traits struct {
 		IsAnonymousProxy    bool `maxminddb:"is_anonymous_proxy"`
+		IsAnycast           bool `maxminddb:"is_anycast"`
 		IsSatelliteProvider bool `maxminddb:"is_satellite_provider"`
 	} `maxminddb:"traits"`
 }
@@ -111,22 +113,31 @@ func (m *MockReader) ASN(ip net.IP) (*geoip2.ASN, error) {
 func (m *MockReader) City(ip net.IP) (*geoip2.City, error) {
 	city := &geoip2.City{}
 	city.City.Names = map[string]string{"en": "Test City"}
+	city.City.GeoNameID = 1001
 	city.Subdivisions = []struct {
 		Names     map[string]string `maxminddb:"names"`
 		IsoCode   string            `maxminddb:"iso_code"`
 		GeoNameID uint              `maxminddb:"geoname_id"`
 	}{
 		{
-			IsoCode: "TS",
-			Names:   map[string]string{"en": "Test Region"},
+			IsoCode:   "TS",
+			Names:     map[string]string{"en": "Test Region"},
+			GeoNameID: 1002,
 		},
 	}
 	city.Country.IsoCode = "TS"
 	city.Country.IsInEuropeanUnion = true
 	city.Country.Names = map[string]string{"en": "Test Country"}
+	city.Country.GeoNameID = 1003
 	city.Continent.Code = "TE"
+	city.Continent.GeoNameID = 1004
+	city.RegisteredCountry.IsoCode = "RC"
+	city.RegisteredCountry.Names = map[string]string{"en": "Registered Country"}
+	city.RegisteredCountry.GeoNameID = 1005
 	city.Location.Latitude = 12.345
 	city.Location.Longitude = 67.890
+	city.Location.AccuracyRadius = 50
+	city.Location.MetroCode = 501
 	city.Location.TimeZone = "America/New_York"
 	city.Postal.Code = "12345"
 
@@ -138,11 +149,41 @@ func (m *MockReader) Country(ip net.IP) (*geoip2.Country, error) {
 	country.Country.IsoCode = "TS"
 	country.Country.IsInEuropeanUnion = true
 	country.Country.Names = map[string]string{"en": "Test Country"}
+	country.Country.GeoNameID = 1003
 	country.Continent.Code = "TE"
+	country.Continent.GeoNameID = 1004
+	country.RegisteredCountry.IsoCode = "RC"
+	country.RegisteredCountry.Names = map[string]string{"en": "Registered Country"}
+	country.RegisteredCountry.GeoNameID = 1005
 
 	return country, nil
 }
 
+func (m *MockReader) AnonymousIP(ip net.IP) (*geoip2.AnonymousIP, error) {
+	return &geoip2.AnonymousIP{
+		IsAnonymous:       true,
+		IsAnonymousVPN:    true,
+		IsHostingProvider: false,
+		IsTorExitNode:     false,
+	}, nil
+}
+
+func (m *MockReader) ISP(ip net.IP) (*geoip2.ISP, error) {
+	return &geoip2.ISP{
+		AutonomousSystemNumber:       12345,
+		AutonomousSystemOrganization: "Test ISP",
+		ISP:                          "Test Broadband",
+	}, nil
+}
+
+func (m *MockReader) ConnectionType(ip net.IP) (*geoip2.ConnectionType, error) {
+	return &geoip2.ConnectionType{ConnectionType: "Cable/DSL"}, nil
+}
+
+func (m *MockReader) Domain(ip net.IP) (*geoip2.Domain, error) {
+	return &geoip2.Domain{Domain: "example.net"}, nil
+}
+
 func (m *MockReader) Close() error {
 	return nil
 }
\ No newline at end of file
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// How long to wait after the last change in the database directory before
+// reopening databases, so that files being copied in aren't read half-written
+var watchDebounce = 2 * time.Second
+
+// watchDatabaseDir reloads databases that an external process drops into
+// dbDir. It is used in offline mode, where the service never downloads
+// anything itself. The watcher stops when stop is closed.
+func watchDatabaseDir(stop <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(dbDir); err != nil {
+		return err
+	}
+	logger.Info("Watching database directory for changes", "dir", dbDir)
+
+	var reload <-chan time.Time
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			logger.Debug("Database directory changed", "event", event.String())
+			reload = time.After(watchDebounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			logger.Error("Database directory watcher error", "error", err)
+		case <-reload:
+			reload = nil
+			reloadChangedDatabases()
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// reloadChangedDatabases reopens every database whose file modification time
+// differs from the one it was loaded with
+func reloadChangedDatabases() {
+	for name, db := range databases {
+		info, err := os.Stat(db.localPath)
+		if err != nil {
+			// Keep serving from the open reader until a new file appears
+			continue
+		}
+
+		db.mutex.RLock()
+		unchanged := db.reader != nil && info.ModTime().Equal(db.lastUpdate)
+		db.mutex.RUnlock()
+		if unchanged {
+			continue
+		}
+
+		reader, err := geoipOpen(db.localPath)
+		if err != nil {
+			logger.Error("Failed to open changed database", "database", name, "error", err)
+			continue
+		}
+
+		db.mutex.Lock()
+		oldReader := db.reader
+		db.reader = reader
+		db.lastUpdate = info.ModTime()
+		db.mutex.Unlock()
+
+		if oldReader != nil {
+			oldReader.Close()
+		}
+		logger.Info("Reloaded database", "database", name, "modified", info.ModTime())
+		markIndexesStale(name)
+	}
+	rebuildStaleIndexes()
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInitDatabasesOffline(t *testing.T) {
+	originalDatabases := databases
+	originalConfig := config
+	originalOpen := geoipOpen
+	defer func() {
+		databases = originalDatabases
+		config = originalConfig
+		geoipOpen = originalOpen
+	}()
+
+	// Any download attempt is a failure
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer server.Close()
+
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "country.mmdb"), []byte("MOCK"), 0444); err != nil {
+		t.Fatalf("Failed to create database file: %v", err)
+	}
+
+	geoipOpen = func(filename string) (Reader, error) {
+		return &MockReader{}, nil
+	}
+	config.Offline = true
+	databases = map[string]*dbConfig{
+		"asn":     {url: server.URL, localPath: filepath.Join(tempDir, "asn.mmdb")},
+		"city":    {url: server.URL, localPath: filepath.Join(tempDir, "city.mmdb")},
+		"country": {url: server.URL, localPath: filepath.Join(tempDir, "country.mmdb")},
+	}
+
+	err := initDatabases()
+	waitForIndexes()
+	if err == nil {
+		t.Fatal("Expected error for missing databases in offline mode, got nil")
+	}
+	for _, name := range []string{"asn", "city"} {
+		if !strings.Contains(err.Error(), name+" (") {
+			t.Errorf("Expected missing %s database to be listed, got: %v", name, err)
+		}
+	}
+	if strings.Contains(err.Error(), "country") {
+		t.Errorf("Existing country database should not be listed, got: %v", err)
+	}
+	if atomic.LoadInt32(&requests) != 0 {
+		t.Errorf("Expected no download attempts in offline mode, got %d", requests)
+	}
+
+	// With every file present the databases open normally
+	databases = map[string]*dbConfig{
+		"country": {url: server.URL, localPath: filepath.Join(tempDir, "country.mmdb")},
+	}
+	err = initDatabases()
+	waitForIndexes()
+	if err != nil {
+		t.Fatalf("initDatabases failed in offline mode: %v", err)
+	}
+	if databases["country"].reader == nil {
+		t.Error("Expected country database to be opened")
+	}
+}
+
+// countingReader is a MockReader that records when it is closed
+type countingReader struct {
+	MockReader
+	closed int32
+}
+
+func (r *countingReader) Close() error {
+	atomic.AddInt32(&r.closed, 1)
+	return nil
+}
+
+func TestReloadChangedDatabases(t *testing.T) {
+	originalDatabases := databases
+	originalOpen := geoipOpen
+	defer func() {
+		databases = originalDatabases
+		geoipOpen = originalOpen
+	}()
+
+	tempDir := t.TempDir()
+	dbPath := filepath.Join(tempDir, "city.mmdb")
+	if err := os.WriteFile(dbPath, []byte("v1"), 0644); err != nil {
+		t.Fatalf("Failed to create database file: %v", err)
+	}
+	info, _ := os.Stat(dbPath)
+
+	var opened int32
+	geoipOpen = func(filename string) (Reader, error) {
+		atomic.AddInt32(&opened, 1)
+		return &countingReader{}, nil
+	}
+
+	oldReader := &countingReader{}
+	databases = map[string]*dbConfig{
+		"city": {localPath: dbPath, reader: oldReader, lastUpdate: info.ModTime()},
+	}
+
+	// Nothing changed, nothing is reopened
+	reloadChangedDatabases()
+	waitForIndexes()
+	if atomic.LoadInt32(&opened) != 0 {
+		t.Errorf("Expected unchanged database not to be reopened")
+	}
+
+	// A replaced file is reopened and the old reader closed
+	newTime := info.ModTime().Add(time.Minute)
+	if err := os.Chtimes(dbPath, newTime, newTime); err != nil {
+		t.Fatalf("Failed to change modification time: %v", err)
+	}
+	reloadChangedDatabases()
+	waitForIndexes()
+	if atomic.LoadInt32(&opened) != 1 {
+		t.Errorf("Expected changed database to be reopened once, got %d", opened)
+	}
+	if databases["city"].reader == oldReader {
+		t.Error("Expected reader to be replaced")
+	}
+	if atomic.LoadInt32(&oldReader.closed) != 1 {
+		t.Error("Expected old reader to be closed")
+	}
+	if !databases["city"].lastUpdate.Equal(newTime) {
+		t.Errorf("Expected lastUpdate %v, got %v", newTime, databases["city"].lastUpdate)
+	}
+}
+
+func TestWatchDatabaseDir(t *testing.T) {
+	originalDatabases := databases
+	originalOpen := geoipOpen
+	originalDbDir := dbDir
+	originalDebounce := watchDebounce
+	defer func() {
+		databases = originalDatabases
+		geoipOpen = originalOpen
+		dbDir = originalDbDir
+		watchDebounce = originalDebounce
+	}()
+
+	dbDir = t.TempDir()
+	watchDebounce = 20 * time.Millisecond
+	dbPath := filepath.Join(dbDir, "asn.mmdb")
+
+	loaded := make(chan struct{}, 1)
+	geoipOpen = func(filename string) (Reader, error) {
+		select {
+		case loaded <- struct{}{}:
+		default:
+		}
+		return &MockReader{}, nil
+	}
+	databases = map[string]*dbConfig{
+		"asn": {localPath: dbPath},
+	}
+
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- watchDatabaseDir(stop) }()
+
+	// Give the watcher time to start, then drop a file in atomically
+	time.Sleep(50 * time.Millisecond)
+	tempPath := filepath.Join(dbDir, "asn.mmdb.tmp")
+	if err := os.WriteFile(tempPath, []byte("MOCK"), 0644); err != nil {
+		t.Fatalf("Failed to write database file: %v", err)
+	}
+	if err := os.Rename(tempPath, dbPath); err != nil {
+		t.Fatalf("Failed to move database file: %v", err)
+	}
+
+	select {
+	case <-loaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Database dropped into the directory was not loaded")
+	}
+
+	close(stop)
+	if err := <-done; err != nil {
+		t.Errorf("watchDatabaseDir returned error: %v", err)
+	}
+	waitForIndexes()
+}
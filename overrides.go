@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Override replaces IPInfo fields for every address in Network. Overrides
+// label ranges the databases get wrong or don't know, like office, VPN and
+// datacenter networks.
+type Override struct {
+	Network *net.IPNet
+	Fields  map[string]json.RawMessage // Replacement values by IPInfo JSON key
+	Tags    []string                   // Free-form labels added to the response
+}
+
+// Keys of IPInfo that describe the address itself and can't be overridden
+var fixedIPInfoFields = map[string]bool{"ip": true, "version": true, "tags": true}
+
+// IPInfo struct field index by JSON key
+var ipInfoFieldIndex = func() map[string]int {
+	index := make(map[string]int)
+	t := reflect.TypeOf(IPInfo{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			index[name] = i
+		}
+	}
+	return index
+}()
+
+var (
+	overridesMutex sync.RWMutex
+	overrideList   []*Override // Sorted by prefix length, longest first
+)
+
+// MarshalJSON writes an override in the flat form it is read from
+func (o *Override) MarshalJSON() ([]byte, error) {
+	entry := make(map[string]interface{}, len(o.Fields)+2)
+	for key, value := range o.Fields {
+		entry[key] = value
+	}
+	entry["network"] = o.Network.String()
+	if len(o.Tags) > 0 {
+		entry["tags"] = o.Tags
+	}
+	return json.Marshal(entry)
+}
+
+// apply merges the override into info. Fields the override sets are shown
+// even if their database is disabled or the address is private.
+func (o *Override) apply(info *IPInfo) {
+	v := reflect.ValueOf(info).Elem()
+	for key, value := range o.Fields {
+		// Decode into a fresh value: unmarshalling into the field would
+		// reuse slices that info shares with the country dataset. The
+		// values were checked against the field types when loading.
+		field := v.Field(ipInfoFieldIndex[key])
+		fresh := reflect.New(field.Type())
+		json.Unmarshal(value, fresh.Interface())
+		field.Set(fresh.Elem())
+		delete(info.omit, key)
+	}
+	info.Tags = append(info.Tags, o.Tags...)
+}
+
+// matchOverride returns the override with the longest prefix containing ip
+func matchOverride(ip net.IP) *Override {
+	overridesMutex.RLock()
+	defer overridesMutex.RUnlock()
+
+	for _, o := range overrideList {
+		if o.Network.Contains(ip) {
+			return o
+		}
+	}
+	return nil
+}
+
+// applyOverrides merges the most specific override for ip into info
+func applyOverrides(info *IPInfo, ip net.IP) {
+	if o := matchOverride(ip); o != nil {
+		o.apply(info)
+	}
+}
+
+// listOverrides returns the loaded overrides, longest prefix first
+func listOverrides() []*Override {
+	overridesMutex.RLock()
+	defer overridesMutex.RUnlock()
+	return overrideList
+}
+
+// reloadOverrides loads the configured overrides file and replaces the
+// active overrides. On error the previous overrides stay active.
+func reloadOverrides() error {
+	var loaded []*Override
+	if config.OverridesFile != "" {
+		var err error
+		if loaded, err = loadOverrides(config.OverridesFile); err != nil {
+			return err
+		}
+	}
+
+	overridesMutex.Lock()
+	overrideList = loaded
+	overridesMutex.Unlock()
+
+	if config.OverridesFile != "" {
+		logger.Info("Loaded overrides", "path", config.OverridesFile, "count", len(loaded))
+	}
+	return nil
+}
+
+// loadOverrides reads an overrides file. The format is chosen by extension:
+// .csv, .json, or .yaml/.yml.
+func loadOverrides(path string) ([]*Override, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []map[string]interface{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".csv":
+		entries, err = parseOverridesCSV(data)
+	case ".json":
+		err = json.Unmarshal(data, &entries)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &entries)
+	default:
+		return nil, fmt.Errorf("%s: unsupported overrides format %q, use .csv, .json or .yaml", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	overrides := make([]*Override, 0, len(entries))
+	for i, entry := range entries {
+		o, err := newOverride(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s: entry %d: %v", path, i+1, err)
+		}
+		overrides = append(overrides, o)
+	}
+
+	// Longest prefix first, so the first match is the most specific one
+	sort.SliceStable(overrides, func(i, j int) bool {
+		ones1, _ := overrides[i].Network.Mask.Size()
+		ones2, _ := overrides[j].Network.Mask.Size()
+		return ones1 > ones2
+	})
+	return overrides, nil
+}
+
+// parseOverridesCSV reads overrides from CSV with a header row naming the
+// columns. Empty cells are ignored and tags are separated by semicolons.
+func parseOverridesCSV(data []byte) ([]map[string]interface{}, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []map[string]interface{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entry := make(map[string]interface{}, len(record))
+		for i, value := range record {
+			if value != "" {
+				entry[strings.TrimSpace(header[i])] = value
+			}
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// newOverride validates a decoded overrides file entry
+func newOverride(entry map[string]interface{}) (*Override, error) {
+	o := &Override{Fields: make(map[string]json.RawMessage)}
+
+	network, ok := entry["network"].(string)
+	if !ok {
+		return nil, fmt.Errorf("network is required")
+	}
+	if _, ipNet, err := net.ParseCIDR(network); err == nil {
+		o.Network = ipNet
+	} else if ip := net.ParseIP(network); ip != nil {
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		o.Network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	} else {
+		return nil, fmt.Errorf("%q is not a valid CIDR or IP address", network)
+	}
+
+	var scratch IPInfo
+	fields := reflect.ValueOf(&scratch).Elem()
+	for key, value := range entry {
+		switch {
+		case key == "network":
+			continue
+		case key == "tags":
+			tags, err := overrideTags(value)
+			if err != nil {
+				return nil, err
+			}
+			o.Tags = tags
+			continue
+		case fixedIPInfoFields[key]:
+			return nil, fmt.Errorf("%s can't be overridden", key)
+		}
+
+		index, ok := ipInfoFieldIndex[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %s", key)
+		}
+		field := fields.Field(index)
+
+		// CSV cells are strings whatever the field type
+		if text, ok := value.(string); ok && field.Kind() != reflect.String {
+			converted, err := convertOverrideValue(text, field.Kind())
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+			value = converted
+		}
+
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		if err := json.Unmarshal(raw, field.Addr().Interface()); err != nil {
+			return nil, fmt.Errorf("%s: invalid value %s", key, raw)
+		}
+		o.Fields[key] = raw
+	}
+	return o, nil
+}
+
+// convertOverrideValue parses a CSV cell for a field of the given kind
+func convertOverrideValue(text string, kind reflect.Kind) (interface{}, error) {
+	switch kind {
+	case reflect.Bool:
+		return strconv.ParseBool(text)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(text, 64)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(text, 10, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseUint(text, 10, 64)
+	case reflect.Slice:
+		return strings.Split(text, ";"), nil
+	}
+	return text, nil
+}
+
+// overrideTags accepts tags as a list or as a semicolon separated string
+func overrideTags(value interface{}) ([]string, error) {
+	switch tags := value.(type) {
+	case string:
+		return strings.Split(tags, ";"), nil
+	case []interface{}:
+		result := make([]string, len(tags))
+		for i, tag := range tags {
+			text, ok := tag.(string)
+			if !ok {
+				return nil, fmt.Errorf("tags must be strings")
+			}
+			result[i] = text
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("tags must be a list of strings")
+}
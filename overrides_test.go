@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeOverridesFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write overrides file: %v", err)
+	}
+	return path
+}
+
+func TestLoadOverridesFormats(t *testing.T) {
+	files := map[string]string{
+		"overrides.csv": "network,city,org,latitude,tags\n" +
+			"# Office networks\n" +
+			"10.1.0.0/16,Berlin,Acme Corp,52.52,office;vpn\n",
+		"overrides.json": `[{"network": "10.1.0.0/16", "city": "Berlin", "org": "Acme Corp", "latitude": 52.52, "tags": ["office", "vpn"]}]`,
+		"overrides.yaml": "- network: 10.1.0.0/16\n  city: Berlin\n  org: Acme Corp\n  latitude: 52.52\n  tags: [office, vpn]\n",
+	}
+
+	for name, content := range files {
+		overrides, err := loadOverrides(writeOverridesFile(t, name, content))
+		if err != nil {
+			t.Errorf("%s: loadOverrides failed: %v", name, err)
+			continue
+		}
+		if len(overrides) != 1 {
+			t.Errorf("%s: expected 1 override, got %d", name, len(overrides))
+			continue
+		}
+
+		info := &IPInfo{}
+		overrides[0].apply(info)
+		if info.City != "Berlin" || info.Org != "Acme Corp" || info.Latitude != 52.52 {
+			t.Errorf("%s: unexpected result %+v", name, info)
+		}
+		if strings.Join(info.Tags, ",") != "office,vpn" {
+			t.Errorf("%s: expected tags office,vpn, got %v", name, info.Tags)
+		}
+	}
+}
+
+func TestLoadOverridesErrors(t *testing.T) {
+	tests := map[string]string{
+		"bad.json":  `[{"city": "Berlin"}]`,
+		"cidr.json": `[{"network": "10.1.0.0/33"}]`,
+		"key.json":  `[{"network": "10.1.0.0/16", "cty": "Berlin"}]`,
+		"ip.json":   `[{"network": "10.1.0.0/16", "ip": "10.1.0.1"}]`,
+		"type.json": `[{"network": "10.1.0.0/16", "latitude": "north"}]`,
+		"type.csv":  "network,in_eu\n10.1.0.0/16,maybe\n",
+		"bad.txt":   "10.1.0.0/16 Berlin",
+	}
+	for name, content := range tests {
+		if _, err := loadOverrides(writeOverridesFile(t, name, content)); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
+func TestOverridesLongestPrefix(t *testing.T) {
+	originalConfig := config
+	originalDatabases := databases
+	defer func() {
+		config = originalConfig
+		databases = originalDatabases
+		overrideList = nil
+	}()
+
+	config.OverridesFile = writeOverridesFile(t, "overrides.json", `[
+		{"network": "10.0.0.0/8", "org": "Acme Corp", "tags": ["internal"]},
+		{"network": "10.1.2.0/24", "city": "Berlin", "tags": ["office"]},
+		{"network": "81.2.69.142", "org": "Acme Datacenter"}
+	]`)
+	if err := reloadOverrides(); err != nil {
+		t.Fatalf("reloadOverrides failed: %v", err)
+	}
+
+	databases = map[string]*dbConfig{
+		"asn":  {reader: &MockReader{}},
+		"city": {reader: &MockReader{}},
+	}
+
+	// The most specific override wins, and its fields show up for private addresses
+	info, err := getIPInfo(net.ParseIP("10.1.2.3"))
+	if err != nil {
+		t.Fatalf("getIPInfo failed: %v", err)
+	}
+	data, _ := json.Marshal(info)
+	var fields map[string]interface{}
+	json.Unmarshal(data, &fields)
+	if fields["city"] != "Berlin" {
+		t.Errorf("Expected city from the /24 override, got %v", fields["city"])
+	}
+	if _, ok := fields["org"]; ok {
+		t.Error("Expected the less specific /8 override not to apply")
+	}
+	if info.AddressType != addressPrivate {
+		t.Errorf("Expected address type to stay private, got %s", info.AddressType)
+	}
+
+	info, _ = getIPInfo(net.ParseIP("10.9.9.9"))
+	if info.Org != "Acme Corp" || len(info.Tags) != 1 || info.Tags[0] != "internal" {
+		t.Errorf("Expected /8 override, got %+v", info)
+	}
+
+	// Overrides are merged over database results
+	info, _ = getIPInfo(net.ParseIP("81.2.69.142"))
+	if info.Org != "Acme Datacenter" || info.ASN != "AS12345" || info.City != "Test City" {
+		t.Errorf("Expected override merged over database data, got %+v", info)
+	}
+
+	// Addresses without an override have no tags
+	info, _ = getIPInfo(net.ParseIP("81.2.69.143"))
+	data, _ = json.Marshal(info)
+	if strings.Contains(string(data), `"tags"`) {
+		t.Errorf("Expected no tags without an override, got %s", data)
+	}
+
+	// A broken file keeps the previous overrides
+	if err := os.WriteFile(config.OverridesFile, []byte("not json"), 0644); err != nil {
+		t.Fatalf("Failed to write overrides file: %v", err)
+	}
+	if err := reloadOverrides(); err == nil {
+		t.Error("Expected reload error for broken file")
+	}
+	if len(listOverrides()) != 3 {
+		t.Errorf("Expected previous overrides to stay active, got %d", len(listOverrides()))
+	}
+}
+
+func TestOverridesKeepCountryDataset(t *testing.T) {
+	originalConfig := config
+	originalDatabases := databases
+	defer func() {
+		config = originalConfig
+		databases = originalDatabases
+		overrideList = nil
+	}()
+
+	config.OverridesFile = writeOverridesFile(t, "overrides.json", `[
+		{"network": "81.2.69.0/24", "languages": ["xx"]}
+	]`)
+	if err := reloadOverrides(); err != nil {
+		t.Fatalf("reloadOverrides failed: %v", err)
+	}
+	databases = map[string]*dbConfig{
+		"country": {reader: &countryReader{countries: map[string]string{"81.2.69.10": "DE", "2.160.0.1": "DE"}}},
+	}
+
+	info, err := getIPInfo(net.ParseIP("81.2.69.10"))
+	if err != nil {
+		t.Fatalf("getIPInfo failed: %v", err)
+	}
+	if len(info.Languages) != 1 || info.Languages[0] != "xx" {
+		t.Errorf("Expected overridden languages, got %v", info.Languages)
+	}
+	if languages := countries["DE"].Languages; len(languages) != 1 || languages[0] != "de" {
+		t.Fatalf("Expected the country dataset to be unchanged, got %v", languages)
+	}
+
+	// Responses don't share the dataset's slices either
+	info, _ = getIPInfo(net.ParseIP("2.160.0.1"))
+	info.Languages[0] = "yy"
+	if languages := countries["DE"].Languages; languages[0] != "de" {
+		t.Errorf("Expected the country dataset to be unchanged, got %v", languages)
+	}
+}
+
+func TestHandleAdmin(t *testing.T) {
+	originalConfig := config
+	originalDatabases := databases
+	defer func() {
+		config = originalConfig
+		databases = originalDatabases
+		overrideList = nil
+	}()
+
+	config.Host = ""
+	config.OverridesFile = writeOverridesFile(t, "overrides.csv", "network,city\n10.1.0.0/16,Berlin\n")
+	if err := reloadOverrides(); err != nil {
+		t.Fatalf("reloadOverrides failed: %v", err)
+	}
+	databases = map[string]*dbConfig{}
+
+	request := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handleRequest(w, req)
+		return w
+	}
+
+	// Without a configured token the admin API is disabled
+	config.AdminToken = ""
+	if w := request("GET", "/admin/overrides", "anything"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 with admin API disabled, got %d", w.Code)
+	}
+
+	config.AdminToken = "secret"
+	if w := request("GET", "/admin/overrides", "wrong"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 with wrong token, got %d", w.Code)
+	}
+
+	w := request("GET", "/admin/overrides", "secret")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"network":"10.1.0.0/16"`) {
+		t.Errorf("Expected override list, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = request("GET", "/admin/overrides/10.1.2.3", "secret")
+	var result struct {
+		Override map[string]interface{} `json:"override"`
+		Result   map[string]interface{} `json:"result"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to decode test response: %v", err)
+	}
+	if result.Override["city"] != "Berlin" || result.Result["city"] != "Berlin" {
+		t.Errorf("Unexpected test response: %s", w.Body.String())
+	}
+
+	w = request("GET", "/admin/overrides/192.0.2.1", "secret")
+	if !strings.Contains(w.Body.String(), `"override":null`) {
+		t.Errorf("Expected no override for unmatched address, got %s", w.Body.String())
+	}
+
+	// Reloading picks up file changes
+	os.WriteFile(config.OverridesFile, []byte("network,city\n10.1.0.0/16,Munich\n10.2.0.0/16,Hamburg\n"), 0644)
+	if w := request("POST", "/admin/reload", "secret"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"overrides":2`) {
+		t.Errorf("Expected reload to load 2 overrides, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := request("GET", "/admin/reload", "secret"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for GET /admin/reload, got %d", w.Code)
+	}
+}
+
+func TestValidateOverridesFile(t *testing.T) {
+	cfg := defaultConfig
+	cfg.OverridesFile = writeOverridesFile(t, "overrides.yaml", "- network: 10.1.0.0/16\n  city: [1, 2]\n")
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "overrides_file") {
+		t.Errorf("Expected overrides_file problem, got %v", err)
+	}
+
+	cfg.OverridesFile = filepath.Join(t.TempDir(), "missing.csv")
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "overrides_file") {
+		t.Errorf("Expected overrides_file problem for missing file, got %v", err)
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Policy is a named list of allow and deny rules. The first matching rule
+// decides; if none matches, Default does.
+type Policy struct {
+	Default string       `json:"default"` // "allow" or "deny" (default)
+	Rules   []PolicyRule `json:"rules"`
+}
+
+// PolicyRule matches addresses by every condition it sets. A list condition
+// matches if any of its values does.
+type PolicyRule struct {
+	Name         string   `json:"name"`
+	Action       string   `json:"action"`        // "allow" or "deny"
+	Countries    []string `json:"countries"`     // ISO 3166-1 alpha-2 or alpha-3 codes
+	Continents   []string `json:"continents"`    // Continent codes such as EU or NA
+	InEU         *bool    `json:"in_eu"`         // EU membership of the country; never matches without a country
+	ASNs         asnList  `json:"asns"`          // AS numbers, as 3320 or "AS3320"
+	AddressTypes []string `json:"address_types"` // Address types such as public or private
+	CIDRs        []string `json:"cidrs"`         // Networks or single addresses
+
+	networks *prefixTable[struct{}]
+}
+
+// asnList reads AS numbers given as numbers or "AS"-prefixed strings
+type asnList []uint
+
+func (l *asnList) UnmarshalJSON(data []byte) error {
+	var values []json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	*l = make(asnList, 0, len(values))
+	for _, value := range values {
+		var number uint
+		if json.Unmarshal(value, &number) != nil {
+			var s string
+			err := json.Unmarshal(value, &s)
+			if err == nil {
+				number, err = parseASN(s)
+			}
+			if err != nil {
+				return fmt.Errorf("invalid AS number %s", value)
+			}
+		}
+		*l = append(*l, number)
+	}
+	return nil
+}
+
+// Values accepted by PolicyRule.AddressTypes and PolicyRule.Continents
+var (
+	policyAddressTypes = []string{addressPublic, addressPrivate, addressLoopback, addressCGNAT,
+		addressReserved, addressMulticast, addressDocumentation, addressBogon}
+	policyContinents = []string{"AF", "AN", "AS", "EU", "NA", "OC", "SA"}
+)
+
+var (
+	policiesMutex sync.RWMutex
+	policies      = map[string]*Policy{}
+)
+
+// reloadPolicies loads the policies file, keeping the current policies if
+// it fails
+func reloadPolicies() error {
+	loaded := map[string]*Policy{}
+	if config.PoliciesFile != "" {
+		var err error
+		if loaded, err = loadPolicies(config.PoliciesFile); err != nil {
+			return err
+		}
+	}
+
+	policiesMutex.Lock()
+	policies = loaded
+	policiesMutex.Unlock()
+
+	if config.PoliciesFile != "" {
+		logger.Info("Loaded policies", "path", config.PoliciesFile, "count", len(loaded))
+	}
+	return nil
+}
+
+// loadPolicies reads a JSON or YAML file mapping policy names to policies
+func loadPolicies(path string) (map[string]*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+	case ".yaml", ".yml":
+		// Decode through JSON so that one set of tags and checks applies
+		var raw interface{}
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if data, err = json.Marshal(raw); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported policies format %q, use .json or .yaml", path, ext)
+	}
+
+	var loaded map[string]*Policy
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&loaded); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for name, policy := range loaded {
+		if policy == nil {
+			return nil, fmt.Errorf("%s: policy %s is empty", path, name)
+		}
+		if err := policy.compile(); err != nil {
+			return nil, fmt.Errorf("%s: policy %s: %v", path, name, err)
+		}
+	}
+	return loaded, nil
+}
+
+// compile checks a policy and normalizes its values for evaluation
+func (p *Policy) compile() error {
+	if p.Default == "" {
+		p.Default = "deny"
+	}
+	if p.Default != "allow" && p.Default != "deny" {
+		return fmt.Errorf("default must be allow or deny, got %q", p.Default)
+	}
+
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		label := fmt.Sprintf("rule %d", i+1)
+		if rule.Name != "" {
+			label = fmt.Sprintf("rule %d (%s)", i+1, rule.Name)
+		}
+		if rule.Action != "allow" && rule.Action != "deny" {
+			return fmt.Errorf("%s: action must be allow or deny, got %q", label, rule.Action)
+		}
+
+		for j, code := range rule.Countries {
+			country, ok := lookupCountry(code)
+			if !ok {
+				return fmt.Errorf("%s: unknown country %q", label, code)
+			}
+			rule.Countries[j] = country.Code
+		}
+		for j, code := range rule.Continents {
+			rule.Continents[j] = strings.ToUpper(code)
+			if !slices.Contains(policyContinents, rule.Continents[j]) {
+				return fmt.Errorf("%s: unknown continent %q, expected one of %s", label, code, strings.Join(policyContinents, ", "))
+			}
+		}
+		for _, addressType := range rule.AddressTypes {
+			if !slices.Contains(policyAddressTypes, addressType) {
+				return fmt.Errorf("%s: unknown address type %q, expected one of %s", label, addressType, strings.Join(policyAddressTypes, ", "))
+			}
+		}
+
+		if len(rule.CIDRs) > 0 {
+			rule.networks = newPrefixTable[struct{}]()
+			for _, cidr := range rule.CIDRs {
+				prefix, err := parsePrefix(cidr)
+				if err != nil {
+					return fmt.Errorf("%s: invalid CIDR %q", label, cidr)
+				}
+				rule.networks.set(prefix, struct{}{})
+			}
+		}
+	}
+	return nil
+}
+
+// matches reports whether the rule applies to an address
+func (rule *PolicyRule) matches(info *IPInfo, ip net.IP) bool {
+	if len(rule.Countries) > 0 && !slices.Contains(rule.Countries, info.CountryCode) {
+		return false
+	}
+	if len(rule.Continents) > 0 && !slices.Contains(rule.Continents, info.ContinentCode) {
+		return false
+	}
+	if rule.InEU != nil && (info.CountryCode == "" || info.InEU != *rule.InEU) {
+		return false
+	}
+	if len(rule.ASNs) > 0 {
+		number, err := parseASN(info.ASN)
+		if err != nil || !slices.Contains(rule.ASNs, number) {
+			return false
+		}
+	}
+	if len(rule.AddressTypes) > 0 && !slices.Contains(rule.AddressTypes, info.AddressType) {
+		return false
+	}
+	if rule.networks != nil {
+		if _, ok := rule.networks.lookup(ip); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// policyDecision is the result of evaluating a policy for an address
+type policyDecision struct {
+	Policy   string       `json:"policy,omitempty"`
+	IP       string       `json:"ip"`
+	Decision string       `json:"decision"` // "allow" or "deny"
+	Rule     *matchedRule `json:"rule"`     // Null when the default applied
+}
+
+// matchedRule identifies the rule that decided
+type matchedRule struct {
+	Index  int    `json:"index"` // Position in the rules list, starting at 1
+	Name   string `json:"name,omitempty"`
+	Action string `json:"action"`
+}
+
+// evaluate returns the decision of the policy for an address
+func (p *Policy) evaluate(info *IPInfo, ip net.IP) policyDecision {
+	decision := policyDecision{IP: info.IP, Decision: p.Default}
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.matches(info, ip) {
+			decision.Decision = rule.Action
+			decision.Rule = &matchedRule{Index: i + 1, Name: rule.Name, Action: rule.Action}
+			break
+		}
+	}
+	return decision
+}
+
+// evaluatePolicy looks up an address and evaluates a policy for it
+func evaluatePolicy(p *Policy, ipAddress string) (policyDecision, *IPInfo, error) {
+	ip := net.ParseIP(ipAddress)
+	if ip == nil {
+		return policyDecision{}, nil, fmt.Errorf("invalid IP address %q", ipAddress)
+	}
+	info, err := getIPInfo(ip)
+	if err != nil {
+		return policyDecision{}, nil, fmt.Errorf("error getting IP info: %v", err)
+	}
+	return p.evaluate(info, ip), info, nil
+}
+
+// lookupPolicy returns the named policy
+func lookupPolicy(name string) (*Policy, bool) {
+	policiesMutex.RLock()
+	defer policiesMutex.RUnlock()
+	policy, ok := policies[name]
+	return policy, ok
+}
+
+// Most addresses a single POST /policy/test request evaluates
+const maxPolicyTestIPs = 1000
+
+// handlePolicy serves the policy API:
+//
+//	GET  /policy/{name}/{ip}  the decision of a policy for an address
+//	POST /policy/test         evaluate a named or inline policy for sample addresses
+func handlePolicy(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/policy/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "test" && r.Method == http.MethodPost:
+		handlePolicyTest(w, r)
+	case len(parts) == 2 && parts[0] != "" && r.Method == http.MethodGet:
+		handlePolicyDecision(w, r, parts[0], parts[1])
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+func handlePolicyDecision(w http.ResponseWriter, r *http.Request, name, ipAddress string) {
+	policy, ok := lookupPolicy(name)
+	if !ok {
+		http.Error(w, "Policy not found", http.StatusNotFound)
+		return
+	}
+
+	decision, _, err := evaluatePolicy(policy, ipAddress)
+	if err != nil {
+		if net.ParseIP(ipAddress) == nil {
+			http.Error(w, "Invalid IP address", http.StatusBadRequest)
+			return
+		}
+		requestLogger(r).Error("Error evaluating policy", "policy", name, "ip", maskIP(ipAddress), "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	decision.Policy = name
+	requestLogger(r).Debug("Evaluated policy", "policy", name, "ip", maskIP(ipAddress), "decision", decision.Decision)
+	writeJSON(w, r, decision)
+}
+
+// policyTestRequest is the body of POST /policy/test. Exactly one of Name
+// and Policy is set; Policy allows trying out a policy before saving it.
+type policyTestRequest struct {
+	Name   string   `json:"name"`
+	Policy *Policy  `json:"policy"`
+	IPs    []string `json:"ips"`
+}
+
+// policyTestResult is a decision together with the fields it was based on
+type policyTestResult struct {
+	policyDecision
+	Error         string `json:"error,omitempty"`
+	CountryCode   string `json:"country_code,omitempty"`
+	ContinentCode string `json:"continent_code,omitempty"`
+	InEU          bool   `json:"in_eu,omitempty"`
+	ASN           string `json:"asn,omitempty"`
+	AddressType   string `json:"address_type,omitempty"`
+}
+
+func handlePolicyTest(w http.ResponseWriter, r *http.Request) {
+	var request policyTestRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		http.Error(w, "Invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if (request.Name == "") == (request.Policy == nil) {
+		http.Error(w, "Invalid request: expected either name or policy", http.StatusBadRequest)
+		return
+	}
+	if len(request.IPs) == 0 || len(request.IPs) > maxPolicyTestIPs {
+		http.Error(w, fmt.Sprintf("Invalid request: expected 1 to %d ips", maxPolicyTestIPs), http.StatusBadRequest)
+		return
+	}
+
+	policy := request.Policy
+	if policy == nil {
+		var ok bool
+		if policy, ok = lookupPolicy(request.Name); !ok {
+			http.Error(w, "Policy not found", http.StatusNotFound)
+			return
+		}
+	} else if err := policy.compile(); err != nil {
+		http.Error(w, "Invalid policy: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results := make([]policyTestResult, 0, len(request.IPs))
+	for _, ipAddress := range request.IPs {
+		decision, info, err := evaluatePolicy(policy, ipAddress)
+		if err != nil {
+			results = append(results, policyTestResult{policyDecision: policyDecision{IP: ipAddress}, Error: err.Error()})
+			continue
+		}
+		decision.Policy = request.Name
+		results = append(results, policyTestResult{
+			policyDecision: decision,
+			CountryCode:    info.CountryCode,
+			ContinentCode:  info.ContinentCode,
+			InEU:           info.InEU,
+			ASN:            info.ASN,
+			AddressType:    info.AddressType,
+		})
+	}
+	writeJSON(w, r, results)
+}
+
+// policyNames returns the loaded policy names, sorted
+func policyNames() []string {
+	policiesMutex.RLock()
+	defer policiesMutex.RUnlock()
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// countryReader returns MockReader data with a real country per IP
+type countryReader struct {
+	MockReader
+	countries map[string]string // IP to country code
+}
+
+func (r *countryReader) Country(ip net.IP) (*geoip2.Country, error) {
+	country, err := r.MockReader.Country(ip)
+	if err != nil {
+		return nil, err
+	}
+	code := r.countries[ip.String()]
+	info, _ := lookupCountry(code)
+	country.Country.IsoCode = code
+	country.Country.IsInEuropeanUnion = code == "DE" || code == "FR"
+	country.Continent.Code = map[string]string{"DE": "EU", "FR": "EU", "GB": "EU", "US": "NA"}[code]
+	if info != nil {
+		country.Country.Names = map[string]string{"en": info.Name}
+	}
+	return country, nil
+}
+
+const testPolicies = `
+eu-only:
+  rules:
+    - name: office
+      action: allow
+      cidrs: [203.0.113.0/24]
+    - name: internal
+      action: allow
+      address_types: [private, loopback]
+    - name: blocked-isp
+      action: deny
+      asns: [AS12345]
+      countries: [FR]
+    - name: eu
+      action: allow
+      in_eu: true
+open:
+  default: allow
+  rules:
+    - action: deny
+      countries: [usa]
+      continents: [na]
+`
+
+func setupPolicies(t *testing.T, content string) string {
+	t.Helper()
+	originalConfig := config
+	originalDatabases := databases
+	t.Cleanup(func() {
+		config = originalConfig
+		databases = originalDatabases
+		reloadPolicies()
+	})
+
+	path := filepath.Join(t.TempDir(), "policies.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config = defaultConfig
+	config.PoliciesFile = path
+	databases = map[string]*dbConfig{
+		"asn": {reader: &MockReader{}},
+		"country": {reader: &countryReader{countries: map[string]string{
+			"2.160.0.1": "DE", "2.8.0.1": "FR", "81.2.69.142": "GB", "8.8.8.8": "US",
+		}}},
+	}
+	if err := reloadPolicies(); err != nil {
+		t.Fatalf("Failed to load policies: %v", err)
+	}
+	return path
+}
+
+func TestPolicyDecisions(t *testing.T) {
+	setupPolicies(t, testPolicies)
+
+	tests := []struct {
+		policy, ip, decision, rule string
+	}{
+		{"eu-only", "2.160.0.1", "allow", "eu"},
+		{"eu-only", "2.8.0.1", "deny", "blocked-isp"},
+		{"eu-only", "81.2.69.142", "deny", ""},
+		{"eu-only", "192.168.1.1", "allow", "internal"},
+		{"eu-only", "203.0.113.7", "allow", "office"},
+		{"open", "8.8.8.8", "deny", ""},
+		{"open", "81.2.69.142", "allow", ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handleRequest(w, httptest.NewRequest("GET", "/policy/"+test.policy+"/"+test.ip, nil))
+		var decision policyDecision
+		if err := json.Unmarshal(w.Body.Bytes(), &decision); err != nil {
+			t.Fatalf("Failed to decode %s: %v", w.Body.String(), err)
+		}
+		if decision.Decision != test.decision || decision.Policy != test.policy || decision.IP != test.ip {
+			t.Errorf("%s %s: expected %s, got %+v", test.policy, test.ip, test.decision, decision)
+		}
+		name := ""
+		if decision.Rule != nil {
+			name = decision.Rule.Name
+		}
+		if name != test.rule {
+			t.Errorf("%s %s: expected rule %q, got %+v", test.policy, test.ip, test.rule, decision.Rule)
+		}
+	}
+
+	for path, status := range map[string]int{
+		"/policy/missing/8.8.8.8": http.StatusNotFound,
+		"/policy/eu-only/invalid": http.StatusBadRequest,
+		"/policy/eu-only":         http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		handleRequest(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != status {
+			t.Errorf("Expected %d for %s, got %d", status, path, w.Code)
+		}
+	}
+}
+
+func TestPolicyReload(t *testing.T) {
+	path := setupPolicies(t, testPolicies)
+
+	// A broken file keeps the loaded policies
+	os.WriteFile(path, []byte("eu-only:\n  rules:\n    - action: maybe\n"), 0644)
+	if err := reloadPolicies(); err == nil || !strings.Contains(err.Error(), "action must be allow or deny") {
+		t.Errorf("Expected invalid action error, got %v", err)
+	}
+	if _, ok := lookupPolicy("open"); !ok {
+		t.Error("Expected previous policies to stay loaded")
+	}
+
+	os.WriteFile(path, []byte("strict: {}\n"), 0644)
+	if err := reloadAll(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if names := policyNames(); len(names) != 1 || names[0] != "strict" {
+		t.Errorf("Expected reloaded policies, got %v", names)
+	}
+	policy, _ := lookupPolicy("strict")
+	if policy.Default != "deny" {
+		t.Errorf("Expected default deny, got %q", policy.Default)
+	}
+}
+
+func TestLoadPoliciesErrors(t *testing.T) {
+	dir := t.TempDir()
+	for content, expected := range map[string]string{
+		`{"p": {"rules": [{"action": "allow", "countries": ["XX"]}]}}`:       "unknown country",
+		`{"p": {"rules": [{"action": "allow", "continents": ["ZZ"]}]}}`:      "unknown continent",
+		`{"p": {"rules": [{"action": "allow", "address_types": ["home"]}]}}`: "unknown address type",
+		`{"p": {"rules": [{"action": "allow", "cidrs": ["10.0.0.0/33"]}]}}`:  "invalid CIDR",
+		`{"p": {"rules": [{"action": "allow", "asns": ["ASX"]}]}}`:           "invalid AS number",
+		`{"p": {"rules": [{"action": "allow", "country": ["DE"]}]}}`:         "unknown field",
+		`{"p": {"default": "block"}}`:                                        "default must be allow or deny",
+	} {
+		path := filepath.Join(dir, "policies.json")
+		os.WriteFile(path, []byte(content), 0644)
+		if _, err := loadPolicies(path); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q for %s, got %v", expected, content, err)
+		}
+	}
+
+	cfg := defaultConfig
+	cfg.PoliciesFile = filepath.Join(dir, "policies.txt")
+	os.WriteFile(cfg.PoliciesFile, []byte("{}"), 0644)
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "policies_file") {
+		t.Errorf("Expected policies_file problem, got %v", err)
+	}
+}
+
+func TestPolicyTest(t *testing.T) {
+	setupPolicies(t, testPolicies)
+
+	post := func(body string) (int, []policyTestResult) {
+		w := httptest.NewRecorder()
+		handleRequest(w, httptest.NewRequest("POST", "/policy/test", strings.NewReader(body)))
+		var results []policyTestResult
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+				t.Fatalf("Failed to decode %s: %v", w.Body.String(), err)
+			}
+		}
+		return w.Code, results
+	}
+
+	status, results := post(`{"name": "eu-only", "ips": ["2.160.0.1", "8.8.8.8", "bad"]}`)
+	if status != http.StatusOK || len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %d %+v", status, results)
+	}
+	if results[0].Decision != "allow" || results[0].CountryCode != "DE" || !results[0].InEU || results[0].ASN != "AS12345" {
+		t.Errorf("Unexpected result %+v", results[0])
+	}
+	if results[1].Decision != "deny" || results[1].Rule != nil {
+		t.Errorf("Expected default deny, got %+v", results[1])
+	}
+	if results[2].Error == "" {
+		t.Errorf("Expected error for invalid IP, got %+v", results[2])
+	}
+
+	// Draft policies can be tried before saving them
+	status, results = post(`{"policy": {"rules": [{"action": "allow", "countries": ["US"]}]}, "ips": ["8.8.8.8", "2.160.0.1"]}`)
+	if status != http.StatusOK || results[0].Decision != "allow" || results[1].Decision != "deny" {
+		t.Errorf("Unexpected draft policy results %d %+v", status, results)
+	}
+
+	for body, expected := range map[string]int{
+		`{"ips": ["8.8.8.8"]}`: http.StatusBadRequest,
+		`{"name": "eu-only", "policy": {}, "ips": ["8.8.8.8"]}`: http.StatusBadRequest,
+		`{"name": "eu-only", "ips": []}`:                        http.StatusBadRequest,
+		`{"name": "missing", "ips": ["8.8.8.8"]}`:               http.StatusNotFound,
+		`{"policy": {"default": "block"}, "ips": ["8.8.8.8"]}`:  http.StatusBadRequest,
+		`not json`: http.StatusBadRequest,
+	} {
+		if status, _ := post(body); status != expected {
+			t.Errorf("Expected %d for %s, got %d", expected, body, status)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handleRequest(w, httptest.NewRequest("GET", "/policy/test", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected GET /policy/test to be rejected, got %d", w.Code)
+	}
+}
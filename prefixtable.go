@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net"
+	"net/netip"
+	"sort"
+)
+
+// prefixTable maps network prefixes to values with longest-prefix lookup.
+// A lookup costs one map access per distinct prefix length in the table,
+// which keeps large published range lists cheap to query.
+type prefixTable[T any] struct {
+	entries  map[netip.Prefix]T
+	lengths4 []int // Distinct IPv4 prefix lengths, longest first
+	lengths6 []int // Distinct IPv6 prefix lengths, longest first
+}
+
+func newPrefixTable[T any]() *prefixTable[T] {
+	return &prefixTable[T]{entries: make(map[netip.Prefix]T)}
+}
+
+// parsePrefix parses a CIDR prefix or a single address. IPv4-mapped IPv6
+// prefixes are stored as IPv4.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if addr := prefix.Addr(); addr.Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(addr.Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked(), nil
+}
+
+// get returns the value stored for exactly prefix
+func (t *prefixTable[T]) get(prefix netip.Prefix) (T, bool) {
+	value, ok := t.entries[prefix.Masked()]
+	return value, ok
+}
+
+// set stores value for prefix, replacing any previous value
+func (t *prefixTable[T]) set(prefix netip.Prefix, value T) {
+	prefix = prefix.Masked()
+	if _, ok := t.entries[prefix]; !ok {
+		if prefix.Addr().Is4() {
+			t.lengths4 = insertLength(t.lengths4, prefix.Bits())
+		} else {
+			t.lengths6 = insertLength(t.lengths6, prefix.Bits())
+		}
+	}
+	t.entries[prefix] = value
+}
+
+func insertLength(lengths []int, bits int) []int {
+	i := sort.Search(len(lengths), func(i int) bool { return lengths[i] <= bits })
+	if i < len(lengths) && lengths[i] == bits {
+		return lengths
+	}
+	lengths = append(lengths, 0)
+	copy(lengths[i+1:], lengths[i:])
+	lengths[i] = bits
+	return lengths
+}
+
+// lookup returns the value of the longest prefix containing ip
+func (t *prefixTable[T]) lookup(ip net.IP) (T, bool) {
+	var zero T
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return zero, false
+	}
+	addr = addr.Unmap()
+
+	lengths := t.lengths6
+	if addr.Is4() {
+		lengths = t.lengths4
+	}
+	for _, bits := range lengths {
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if value, ok := t.entries[prefix]; ok {
+			return value, true
+		}
+	}
+	return zero, false
+}
+
+// len returns the number of prefixes in the table
+func (t *prefixTable[T]) len() int {
+	return len(t.entries)
+}
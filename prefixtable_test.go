@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPrefixTable(t *testing.T) {
+	table := newPrefixTable[string]()
+	for prefix, value := range map[string]string{
+		"10.0.0.0/8":           "eight",
+		"10.1.0.0/16":          "sixteen",
+		"10.1.2.3":             "host",
+		"2001:db8::/32":        "v6",
+		"::ffff:192.0.2.0/120": "mapped",
+	} {
+		p, err := parsePrefix(prefix)
+		if err != nil {
+			t.Fatalf("parsePrefix(%q) failed: %v", prefix, err)
+		}
+		table.set(p, value)
+	}
+
+	tests := map[string]string{
+		"10.9.9.9":        "eight",
+		"10.1.9.9":        "sixteen",
+		"10.1.2.3":        "host",
+		"::ffff:10.1.2.3": "host",
+		"2001:db8:1::1":   "v6",
+		"192.0.2.7":       "mapped",
+		"11.0.0.1":        "",
+		"2001:db9::1":     "",
+	}
+	for ip, expected := range tests {
+		value, ok := table.lookup(net.ParseIP(ip))
+		if value != expected || ok != (expected != "") {
+			t.Errorf("lookup(%s) = %q, %v; expected %q", ip, value, ok, expected)
+		}
+	}
+
+	if table.len() != 5 {
+		t.Errorf("Expected 5 prefixes, got %d", table.len())
+	}
+	if _, err := parsePrefix("10.0.0.0/33"); err == nil {
+		t.Error("Expected error for invalid prefix")
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// RangeMaxPrefix limits the size of /ipgeo/range/{cidr} walks
+type RangeMaxPrefix struct {
+	IPv4 int `json:"ipv4"` // Shortest IPv4 prefix length accepted
+	IPv6 int `json:"ipv6"` // Shortest IPv6 prefix length accepted
+}
+
+var defaultRangeMaxPrefix = RangeMaxPrefix{IPv4: 16, IPv6: 48}
+
+// Default limit on the networks a range lookup collects before writing
+const defaultRangeMaxNetworks = 10000
+
+// errTooManyNetworks is returned by walks that exceed their limit
+var errTooManyNetworks = errors.New("too many networks")
+
+// Number of networks written between flushes of a range response
+const rangeFlushInterval = 256
+
+// networkIterator walks the networks of a MaxMind database, like
+// *maxminddb.Networks
+type networkIterator interface {
+	Next() bool
+	Network(result any) (*net.IPNet, error)
+	Err() error
+}
+
+// networkWalker is implemented by readers that can list the networks in a
+// prefix. Readers that don't are skipped by range lookups.
+type networkWalker interface {
+	Networks() networkIterator
+	NetworksWithin(network *net.IPNet) networkIterator
+}
+
+// mmdbReader decodes geoip2 records straight from a maxminddb.Reader, which
+// can also walk the database's networks. geoip2-golang doesn't expose its
+// reader, so using both would map every file twice.
+type mmdbReader struct {
+	networks *maxminddb.Reader
+}
+
+// openMMDB opens a database for both lookups and network walks
+func openMMDB(filename string) (Reader, error) {
+	reader, err := maxminddb.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &mmdbReader{networks: reader}, nil
+}
+
+// Substrings of the database types each lookup method reads, as accepted
+// by geoip2.Reader
+var (
+	locationDatabaseTypes = []string{"City", "Country", "Enterprise"}
+	asnDatabaseTypes      = []string{"ASN", "ISP"}
+)
+
+// lookup decodes the record of ip into result, or fails like geoip2.Reader
+// if the database type doesn't hold the records method reads
+func (r *mmdbReader) lookup(method string, types []string, ip net.IP, result any) error {
+	databaseType := r.networks.Metadata.DatabaseType
+	for _, t := range types {
+		if strings.Contains(databaseType, t) {
+			return r.networks.Lookup(ip, result)
+		}
+	}
+	return geoip2.InvalidMethodError{Method: method, DatabaseType: databaseType}
+}
+
+func (r *mmdbReader) ASN(ip net.IP) (*geoip2.ASN, error) {
+	var record geoip2.ASN
+	return &record, r.lookup("ASN", asnDatabaseTypes, ip, &record)
+}
+
+func (r *mmdbReader) City(ip net.IP) (*geoip2.City, error) {
+	var record geoip2.City
+	return &record, r.lookup("City", locationDatabaseTypes, ip, &record)
+}
+
+func (r *mmdbReader) Country(ip net.IP) (*geoip2.Country, error) {
+	var record geoip2.Country
+	return &record, r.lookup("Country", locationDatabaseTypes, ip, &record)
+}
+
+func (r *mmdbReader) AnonymousIP(ip net.IP) (*geoip2.AnonymousIP, error) {
+	var record geoip2.AnonymousIP
+	return &record, r.lookup("AnonymousIP", []string{"Anonymous-IP"}, ip, &record)
+}
+
+func (r *mmdbReader) ISP(ip net.IP) (*geoip2.ISP, error) {
+	var record geoip2.ISP
+	return &record, r.lookup("ISP", []string{"ISP"}, ip, &record)
+}
+
+func (r *mmdbReader) ConnectionType(ip net.IP) (*geoip2.ConnectionType, error) {
+	var record geoip2.ConnectionType
+	return &record, r.lookup("ConnectionType", []string{"Connection-Type"}, ip, &record)
+}
+
+func (r *mmdbReader) Domain(ip net.IP) (*geoip2.Domain, error) {
+	var record geoip2.Domain
+	return &record, r.lookup("Domain", []string{"Domain"}, ip, &record)
+}
+
+func (r *mmdbReader) Networks() networkIterator {
+	return r.networks.Networks(maxminddb.SkipAliasedNetworks)
+}
+
+func (r *mmdbReader) NetworksWithin(network *net.IPNet) networkIterator {
+	return r.networks.NetworksWithin(network, maxminddb.SkipAliasedNetworks)
+}
+
+func (r *mmdbReader) Close() error {
+	return r.networks.Close()
+}
+
+// rangeNetwork is a line of the /ipgeo/range/{cidr} response
+type rangeNetwork struct {
+	Network     string `json:"network"`
+	CountryCode string `json:"country_code,omitempty"`
+	CountryName string `json:"country_name,omitempty"`
+	City        string `json:"city,omitempty"`
+	ASN         string `json:"asn,omitempty"`
+	Org         string `json:"org,omitempty"`
+}
+
+// merge copies the fields set in other
+func (n *rangeNetwork) merge(other rangeNetwork) {
+	if other.CountryCode != "" {
+		n.CountryCode, n.CountryName = other.CountryCode, other.CountryName
+	}
+	if other.City != "" {
+		n.City = other.City
+	}
+	if other.ASN != "" {
+		n.ASN, n.Org = other.ASN, other.Org
+	}
+}
+
+// rangeEntry is a database network clipped to the requested prefix
+type rangeEntry struct {
+	first, last netip.Addr
+	data        rangeNetwork
+}
+
+// rangeLocationRecord decodes the parts of a City or Country record that
+// range lookups report
+type rangeLocationRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		IsoCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+}
+
+func decodeRangeLocation(it networkIterator, data *rangeNetwork) (*net.IPNet, error) {
+	var record rangeLocationRecord
+	network, err := it.Network(&record)
+	data.CountryCode = record.Country.IsoCode
+	data.CountryName = record.Country.Names["en"]
+	data.City = record.City.Names["en"]
+	return network, err
+}
+
+func decodeRangeASN(it networkIterator, data *rangeNetwork) (*net.IPNet, error) {
+	var record geoip2.ASN
+	network, err := it.Network(&record)
+	data.ASN = fmt.Sprintf("AS%d", record.AutonomousSystemNumber)
+	data.Org = record.AutonomousSystemOrganization
+	return network, err
+}
+
+// walkDatabase lists the networks of a database inside prefix, failing with
+// errTooManyNetworks after limit of them unless limit is negative. It
+// reports false if the database isn't loaded or can't be walked.
+func walkDatabase(name string, prefix netip.Prefix, limit int, decode func(networkIterator, *rangeNetwork) (*net.IPNet, error)) ([]rangeEntry, bool, error) {
+	var entries []rangeEntry
+	walked := false
+	_, err := readDatabase(name, func(reader Reader) error {
+		walker, ok := reader.(networkWalker)
+		if !ok {
+			return nil
+		}
+		walked = true
+
+		within := &net.IPNet{IP: prefix.Addr().AsSlice(), Mask: net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen())}
+		it := walker.NetworksWithin(within)
+		for it.Next() {
+			if limit >= 0 && len(entries) >= limit {
+				return errTooManyNetworks
+			}
+			var data rangeNetwork
+			network, err := decode(it, &data)
+			if err != nil {
+				return err
+			}
+			addr, _ := netip.AddrFromSlice(network.IP)
+			bits, _ := network.Mask.Size()
+			found := netip.PrefixFrom(addr.Unmap(), bits)
+			// A network containing the whole prefix is reported as is
+			if found.Bits() < prefix.Bits() {
+				found = prefix
+			}
+			entries = append(entries, rangeEntry{first: found.Addr(), last: lastAddr(found), data: data})
+		}
+		return it.Err()
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("%s database: %w", name, err)
+	}
+	return entries, walked, nil
+}
+
+// walkLocation lists the networks inside prefix from the city database, or
+// the country database if there is no city database
+func walkLocation(prefix netip.Prefix, limit int) ([]rangeEntry, bool, error) {
+	for _, name := range []string{"city", "country"} {
+		entries, ok, err := walkDatabase(name, prefix, limit, decodeRangeLocation)
+		if err != nil || ok {
+			return entries, ok, err
+		}
+	}
+	return nil, false, nil
+}
+
+// mergeRanges splits prefix at every boundary of the sorted, non-overlapping
+// entry lists and calls emit for each address range covered by at least one
+// list, with the data of every list covering it
+func mergeRanges(prefix netip.Prefix, lists [][]rangeEntry, emit func(first, last netip.Addr, data rangeNetwork) error) error {
+	next := make([]int, len(lists))
+	pos, end := prefix.Addr(), lastAddr(prefix)
+	for {
+		var data rangeNetwork
+		covered := false
+		last := end
+		var nextStart netip.Addr
+		for i, list := range lists {
+			for next[i] < len(list) && list[next[i]].last.Less(pos) {
+				next[i]++
+			}
+			if next[i] == len(list) {
+				continue
+			}
+			entry := list[next[i]]
+			if entry.first.Compare(pos) <= 0 {
+				covered = true
+				data.merge(entry.data)
+				if entry.last.Less(last) {
+					last = entry.last
+				}
+			} else if !nextStart.IsValid() || entry.first.Less(nextStart) {
+				nextStart = entry.first
+			}
+		}
+
+		if !covered {
+			// Skip the gap to the next network any database knows
+			if !nextStart.IsValid() {
+				return nil
+			}
+			pos = nextStart
+			continue
+		}
+		if nextStart.IsValid() && nextStart.Prev().Less(last) {
+			last = nextStart.Prev()
+		}
+		if err := emit(pos, last, data); err != nil {
+			return err
+		}
+		if last == end {
+			return nil
+		}
+		pos = last.Next()
+	}
+}
+
+// rangePrefixes returns the smallest list of prefixes covering first to last
+func rangePrefixes(first, last netip.Addr) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for {
+		bits := first.BitLen()
+		for bits > 0 {
+			wider := netip.PrefixFrom(first, bits-1).Masked()
+			if wider.Addr() != first || last.Less(lastAddr(wider)) {
+				break
+			}
+			bits--
+		}
+		prefix := netip.PrefixFrom(first, bits)
+		prefixes = append(prefixes, prefix)
+
+		if prefixLast := lastAddr(prefix); prefixLast != last {
+			first = prefixLast.Next()
+		} else {
+			return prefixes
+		}
+	}
+}
+
+// lastAddr returns the highest address in prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Masked().Addr().AsSlice()
+	for i := prefix.Bits(); i < len(bytes)*8; i++ {
+		bytes[i/8] |= 1 << (7 - i%8)
+	}
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
+
+// handleRangeLookup serves /ipgeo/range/{cidr} with one JSON line per
+// distinct network inside the prefix, as found in the city (or country) and
+// ASN databases
+func handleRangeLookup(w http.ResponseWriter, r *http.Request, cidr string) {
+	reqLogger := requestLogger(r)
+
+	prefix, err := parsePrefix(cidr)
+	if err != nil {
+		http.Error(w, "Invalid CIDR", http.StatusBadRequest)
+		return
+	}
+	maxPrefix := config.RangeMaxPrefix.IPv6
+	if prefix.Addr().Is4() {
+		maxPrefix = config.RangeMaxPrefix.IPv4
+	}
+	if prefix.Bits() < maxPrefix {
+		http.Error(w, fmt.Sprintf("Prefix too large, the limit is /%d", maxPrefix), http.StatusBadRequest)
+		return
+	}
+
+	// Collect the networks first so that no database lock is held while
+	// writing to a slow client. range_max_networks bounds the memory this
+	// takes.
+	var lists [][]rangeEntry
+	collected := 0
+	for _, walk := range []func(netip.Prefix, int) ([]rangeEntry, bool, error){
+		walkLocation,
+		func(prefix netip.Prefix, limit int) ([]rangeEntry, bool, error) {
+			return walkDatabase("asn", prefix, limit, decodeRangeASN)
+		},
+	} {
+		limit := -1
+		if config.RangeMaxNetworks > 0 {
+			limit = config.RangeMaxNetworks - collected
+		}
+		entries, ok, err := walk(prefix, limit)
+		if errors.Is(err, errTooManyNetworks) {
+			http.Error(w, fmt.Sprintf("Prefix covers more than %d networks, the limit is range_max_networks", config.RangeMaxNetworks), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			reqLogger.Error("Error walking database", "cidr", maskPath(cidr), "error", err)
+			http.Error(w, fmt.Sprintf("Error walking database: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if ok {
+			lists = append(lists, entries)
+			collected += len(entries)
+		}
+	}
+	if len(lists) == 0 {
+		http.Error(w, "Range lookups need the city, country or ASN database", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	written := 0
+	err = mergeRanges(prefix, lists, func(first, last netip.Addr, data rangeNetwork) error {
+		for _, network := range rangePrefixes(first, last) {
+			data.Network = network.String()
+			if err := encoder.Encode(data); err != nil {
+				return err
+			}
+			written++
+			if flusher != nil && written%rangeFlushInterval == 0 {
+				flusher.Flush()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		reqLogger.Debug("Range response aborted", "cidr", maskPath(cidr), "error", err)
+		return
+	}
+	reqLogger.Debug("Successfully processed range", "cidr", maskPath(cidr), "networks", written)
+}
+
+// checkRangeMaxPrefix validates the range_max_prefix section
+func (v *configValidator) checkRangeMaxPrefix(limits RangeMaxPrefix) {
+	if limits.IPv4 < 0 || limits.IPv4 > 32 {
+		v.addf("range_max_prefix.ipv4", "%d is out of range 0-32", limits.IPv4)
+	}
+	if limits.IPv6 < 0 || limits.IPv6 > 128 {
+		v.addf("range_max_prefix.ipv6", "%d is out of range 0-128", limits.IPv6)
+	}
+}
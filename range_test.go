@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// walkableReader adds fixed network walks to MockReader
+type walkableReader struct {
+	MockReader
+	networks []fakeNetwork
+}
+
+type fakeNetwork struct {
+	cidr   string
+	record any
+}
+
+func (r *walkableReader) Networks() networkIterator {
+	return &fakeNetworks{networks: r.networks, index: -1}
+}
+
+// NetworksWithin returns the networks overlapping network, which like in a
+// real database are either inside it or contain all of it
+func (r *walkableReader) NetworksWithin(network *net.IPNet) networkIterator {
+	var within []fakeNetwork
+	for _, candidate := range r.networks {
+		_, candidateNet, _ := net.ParseCIDR(candidate.cidr)
+		if candidateNet.Contains(network.IP) || network.Contains(candidateNet.IP) {
+			within = append(within, candidate)
+		}
+	}
+	return &fakeNetworks{networks: within, index: -1}
+}
+
+type fakeNetworks struct {
+	networks []fakeNetwork
+	index    int
+}
+
+func (n *fakeNetworks) Next() bool {
+	n.index++
+	return n.index < len(n.networks)
+}
+
+func (n *fakeNetworks) Network(result any) (*net.IPNet, error) {
+	network := n.networks[n.index]
+	// Other record types are left empty, as if none of their fields were
+	// in the database
+	if record := reflect.ValueOf(network.record); record.Type().AssignableTo(reflect.TypeOf(result).Elem()) {
+		reflect.ValueOf(result).Elem().Set(record)
+	}
+	_, ipNet, err := net.ParseCIDR(network.cidr)
+	return ipNet, err
+}
+
+func (n *fakeNetworks) Err() error {
+	return nil
+}
+
+func locationRecord(countryCode, countryName, city string) rangeLocationRecord {
+	var record rangeLocationRecord
+	record.Country.IsoCode = countryCode
+	record.Country.Names = map[string]string{"en": countryName}
+	record.City.Names = map[string]string{"en": city}
+	return record
+}
+
+func TestRangePrefixes(t *testing.T) {
+	tests := []struct {
+		first, last string
+		expected    []string
+	}{
+		{"10.0.0.0", "10.0.0.191", []string{"10.0.0.0/25", "10.0.0.128/26"}},
+		{"10.0.0.1", "10.0.0.4", []string{"10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/32"}},
+		{"0.0.0.0", "255.255.255.255", []string{"0.0.0.0/0"}},
+		{"2001:db8::", "2001:db8::ffff", []string{"2001:db8::/112"}},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, prefix := range rangePrefixes(netip.MustParseAddr(test.first), netip.MustParseAddr(test.last)) {
+			got = append(got, prefix.String())
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("rangePrefixes(%s, %s) = %v, expected %v", test.first, test.last, got, test.expected)
+		}
+	}
+}
+
+func TestHandleRangeLookup(t *testing.T) {
+	originalDatabases := databases
+	originalConfig := config
+	defer func() {
+		databases = originalDatabases
+		config = originalConfig
+	}()
+
+	config = defaultConfig
+	databases = map[string]*dbConfig{
+		"city": {reader: &walkableReader{networks: []fakeNetwork{
+			{"81.2.68.0/23", locationRecord("GB", "United Kingdom", "London")},
+			{"81.2.70.0/24", locationRecord("GB", "United Kingdom", "")},
+		}}},
+		// The ASN network contains the whole requested prefix
+		"asn": {reader: &walkableReader{networks: []fakeNetwork{
+			{"81.2.64.0/20", geoip2.ASN{AutonomousSystemNumber: 20712, AutonomousSystemOrganization: "Andrews & Arnold Ltd"}},
+		}}},
+	}
+
+	w := httptest.NewRecorder()
+	handleRequest(w, httptest.NewRequest("GET", "/ipgeo/range/81.2.68.0/22", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("Expected JSON lines, got %d %s", w.Code, w.Body.String())
+	}
+
+	var got []rangeNetwork
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var network rangeNetwork
+		if err := json.Unmarshal(scanner.Bytes(), &network); err != nil {
+			t.Fatalf("Invalid line %q: %v", scanner.Text(), err)
+		}
+		got = append(got, network)
+	}
+	org := "Andrews & Arnold Ltd"
+	expected := []rangeNetwork{
+		{Network: "81.2.68.0/23", CountryCode: "GB", CountryName: "United Kingdom", City: "London", ASN: "AS20712", Org: org},
+		{Network: "81.2.70.0/24", CountryCode: "GB", CountryName: "United Kingdom", ASN: "AS20712", Org: org},
+		{Network: "81.2.71.0/24", ASN: "AS20712", Org: org},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected networks %+v, got %+v", expected, got)
+	}
+
+	// range_max_networks caps the networks collected from both databases
+	config.RangeMaxNetworks = 2
+	w = httptest.NewRecorder()
+	handleRequest(w, httptest.NewRequest("GET", "/ipgeo/range/81.2.68.0/22", nil))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "more than 2 networks") {
+		t.Errorf("Expected 400 above the network limit, got %d %s", w.Code, w.Body.String())
+	}
+	config.RangeMaxNetworks = 3
+	w = httptest.NewRecorder()
+	handleRequest(w, httptest.NewRequest("GET", "/ipgeo/range/81.2.68.0/22", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected 200 at the network limit, got %d %s", w.Code, w.Body.String())
+	}
+	config.RangeMaxNetworks = defaultConfig.RangeMaxNetworks
+
+	// Gaps no database knows are left out
+	databases = map[string]*dbConfig{
+		"city": databases["city"],
+	}
+	w = httptest.NewRecorder()
+	handleRequest(w, httptest.NewRequest("GET", "/ipgeo/range/81.2.68.0/22", nil))
+	if lines := strings.Count(w.Body.String(), "\n"); lines != 2 {
+		t.Errorf("Expected 2 networks without the ASN database, got %s", w.Body.String())
+	}
+
+	for path, status := range map[string]int{
+		"/ipgeo/range/81.2.0.0/15":    http.StatusBadRequest,
+		"/ipgeo/range/2001:db8::/31":  http.StatusBadRequest,
+		"/ipgeo/range/2001:db8::/47":  http.StatusBadRequest,
+		"/ipgeo/range/not-a-cidr":     http.StatusBadRequest,
+		"/ipgeo/range/81.2.69.142/32": http.StatusOK,
+	} {
+		w = httptest.NewRecorder()
+		handleRequest(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != status {
+			t.Errorf("Expected %d for %s, got %d", status, path, w.Code)
+		}
+	}
+
+	// Readers that can't walk networks aren't used
+	databases = map[string]*dbConfig{"asn": {reader: &MockReader{}}}
+	w = httptest.NewRecorder()
+	handleRequest(w, httptest.NewRequest("GET", "/ipgeo/range/81.2.68.0/22", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without walkable databases, got %d", w.Code)
+	}
+}
+
+func TestOpenMMDB(t *testing.T) {
+	reader, err := openMMDB(writeTestMMDB(t, "GeoIP2-Country", map[string]any{
+		"country": map[string]any{"iso_code": "AU"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	ip := net.ParseIP("81.2.69.142")
+	if country, err := reader.Country(ip); err != nil || country.Country.IsoCode != "AU" {
+		t.Errorf("Expected AU from the country lookup, got %+v %v", country, err)
+	}
+	if city, err := reader.City(ip); err != nil || city.Country.IsoCode != "AU" {
+		t.Errorf("Expected city lookups to work on a country database, got %+v %v", city, err)
+	}
+	var invalid geoip2.InvalidMethodError
+	if _, err := reader.ASN(ip); !errors.As(err, &invalid) || invalid.Method != "ASN" {
+		t.Errorf("Expected an invalid method error for an ASN lookup, got %v", err)
+	}
+
+	// The same reader walks the networks
+	it := reader.(networkWalker).Networks()
+	networks := 0
+	for it.Next() {
+		var record rangeLocationRecord
+		network, err := it.Network(&record)
+		if err != nil || network.String() != "0.0.0.0/1" || record.Country.IsoCode != "AU" {
+			t.Errorf("Unexpected network %v %+v %v", network, record, err)
+		}
+		networks++
+	}
+	if it.Err() != nil || networks != 1 {
+		t.Errorf("Expected one network, got %d %v", networks, it.Err())
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// searchIndex maps locations of the city database to their networks. It is
+// built by walking the whole database and never changed afterwards.
+type searchIndex struct {
+	networks  []packedPrefix   // Every network, in address order
+	locations []searchLocation // Distinct locations
+	byLoc     [][]uint32       // Indexes into networks for each location
+}
+
+// packedPrefix stores a prefix in 17 bytes instead of netip.Prefix's 32
+type packedPrefix struct {
+	addr [16]byte
+	bits uint8 // Prefix length of the IPv6 or IPv4-mapped address
+}
+
+func packPrefix(prefix netip.Prefix) packedPrefix {
+	bits := prefix.Bits()
+	if prefix.Addr().Is4() {
+		bits += 96
+	}
+	return packedPrefix{addr: prefix.Addr().As16(), bits: uint8(bits)}
+}
+
+func (p packedPrefix) prefix() netip.Prefix {
+	addr := netip.AddrFrom16(p.addr)
+	if addr.Is4In6() {
+		return netip.PrefixFrom(addr.Unmap(), int(p.bits)-96)
+	}
+	return netip.PrefixFrom(addr, int(p.bits))
+}
+
+// searchLocation is the part of a city record networks are searched by
+type searchLocation struct {
+	CountryCode string
+	RegionCode  string
+	Region      string
+	City        string
+}
+
+// searchRecord decodes the fields of a City record the index needs
+type searchRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		IsoCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+}
+
+var (
+	searchIndexMutex sync.RWMutex
+	searchIdx        *searchIndex // nil until the city database has been walked
+)
+
+// buildSearchIndex walks the city database and replaces the search index.
+// The index is cleared if the database isn't loaded or can't be walked.
+func buildSearchIndex() {
+	start := time.Now()
+	index := &searchIndex{}
+	locationIDs := map[searchLocation]uint32{}
+	walked := false
+	_, err := readDatabase("city", func(reader Reader) error {
+		walker, ok := reader.(networkWalker)
+		if !ok {
+			return nil
+		}
+		walked = true
+
+		it := walker.Networks()
+		for it.Next() {
+			var record searchRecord
+			network, err := it.Network(&record)
+			if err != nil {
+				return err
+			}
+			location := searchLocation{CountryCode: record.Country.IsoCode, City: record.City.Names["en"]}
+			if len(record.Subdivisions) > 0 {
+				location.RegionCode = record.Subdivisions[0].IsoCode
+				location.Region = record.Subdivisions[0].Names["en"]
+			}
+
+			id, ok := locationIDs[location]
+			if !ok {
+				id = uint32(len(index.locations))
+				locationIDs[location] = id
+				index.locations = append(index.locations, location)
+				index.byLoc = append(index.byLoc, nil)
+			}
+
+			addr, _ := netip.AddrFromSlice(network.IP)
+			bits, _ := network.Mask.Size()
+			index.byLoc[id] = append(index.byLoc[id], uint32(len(index.networks)))
+			index.networks = append(index.networks, packPrefix(netip.PrefixFrom(addr.Unmap(), bits)))
+		}
+		return it.Err()
+	})
+	if err != nil {
+		// Keep serving the previous index
+		logger.Error("Failed to build search index", "error", err)
+		return
+	}
+	if !walked {
+		index = nil
+	}
+
+	searchIndexMutex.Lock()
+	searchIdx = index
+	searchIndexMutex.Unlock()
+
+	if index != nil {
+		logger.Info("Built search index", "networks", len(index.networks), "locations", len(index.locations),
+			"memory_bytes", index.memory(), "duration_ms", time.Since(start).Milliseconds())
+	}
+}
+
+// memory estimates the bytes used by the index
+func (index *searchIndex) memory() int64 {
+	const (
+		stringHeader = 16
+		sliceHeader  = 24
+	)
+	size := int64(len(index.networks)) * 17
+	for i, location := range index.locations {
+		size += 4*stringHeader + int64(len(location.CountryCode)+len(location.RegionCode)+len(location.Region)+len(location.City))
+		size += sliceHeader + int64(cap(index.byLoc[i]))*4
+	}
+	return size
+}
+
+// searchQuery holds the /search filters, upper-cased for comparison
+type searchQuery struct {
+	country, region, city string
+	asn                   uint
+	hasASN                bool
+}
+
+// matches reports whether a location passes the location filters
+func (q searchQuery) matches(location searchLocation) bool {
+	return (q.country == "" || location.CountryCode == q.country) &&
+		(q.region == "" || strings.EqualFold(location.RegionCode, q.region) || strings.EqualFold(location.Region, q.region)) &&
+		(q.city == "" || strings.EqualFold(location.City, q.city))
+}
+
+// searchMatch is a network found by a search and the location it maps to
+type searchMatch struct {
+	prefix   netip.Prefix
+	location *searchLocation
+}
+
+// searchResult is a line of the /search JSON lines response
+type searchResult struct {
+	Network     string `json:"network"`
+	CountryCode string `json:"country_code,omitempty"`
+	RegionCode  string `json:"region_code,omitempty"`
+	Region      string `json:"region,omitempty"`
+	City        string `json:"city,omitempty"`
+	ASN         string `json:"asn,omitempty"`
+}
+
+// search returns the networks of the index matching the location filters,
+// in address order
+func (index *searchIndex) search(q searchQuery) []searchMatch {
+	type hit struct {
+		network  uint32
+		location uint32
+	}
+	var hits []hit
+	for id, location := range index.locations {
+		if q.matches(location) {
+			for _, network := range index.byLoc[id] {
+				hits = append(hits, hit{network, uint32(id)})
+			}
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].network < hits[j].network })
+
+	matches := make([]searchMatch, len(hits))
+	for i, h := range hits {
+		matches[i] = searchMatch{prefix: index.networks[h.network].prefix(), location: &index.locations[h.location]}
+	}
+	return matches
+}
+
+// intersectMatches keeps the parts of matches inside prefixes. Both lists
+// are in address order and their prefixes either nest or don't overlap, so
+// each overlap is the more specific of the two prefixes.
+func intersectMatches(matches []searchMatch, prefixes []netip.Prefix) []searchMatch {
+	var result []searchMatch
+	i, j := 0, 0
+	for i < len(matches) && j < len(prefixes) {
+		match, prefix := matches[i], prefixes[j]
+		switch {
+		case match.prefix.Overlaps(prefix) && match.prefix.Bits() >= prefix.Bits():
+			result = append(result, match)
+			i++
+		case match.prefix.Overlaps(prefix):
+			result = append(result, searchMatch{prefix: prefix, location: match.location})
+			j++
+		case match.prefix.Addr().Less(prefix.Addr()):
+			i++
+		default:
+			j++
+		}
+	}
+	return result
+}
+
+// handleSearch serves GET /search?country=&region=&city=&asn= with the
+// networks mapped to a location, as JSON lines or, with format=cidr, one
+// prefix per line
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	reqLogger := requestLogger(r)
+	params := r.URL.Query()
+
+	q := searchQuery{region: params.Get("region"), city: params.Get("city")}
+	if country := params.Get("country"); country != "" {
+		info, ok := lookupCountry(country)
+		if !ok {
+			http.Error(w, "Unknown country", http.StatusBadRequest)
+			return
+		}
+		q.country = info.Code
+	}
+	if asn := params.Get("asn"); asn != "" {
+		number, err := parseASN(asn)
+		if err != nil {
+			http.Error(w, "Invalid AS number", http.StatusBadRequest)
+			return
+		}
+		q.asn, q.hasASN = number, true
+	}
+	locationFilter := q.country != "" || q.region != "" || q.city != ""
+	if !locationFilter && !q.hasASN {
+		http.Error(w, "At least one of country, region, city or asn is required", http.StatusBadRequest)
+		return
+	}
+
+	format := params.Get("format")
+	if format == "" {
+		format = "jsonl"
+	}
+	if format != "jsonl" && format != "cidr" {
+		http.Error(w, "Unknown format, expected jsonl or cidr", http.StatusBadRequest)
+		return
+	}
+
+	var matches []searchMatch
+	if locationFilter {
+		searchIndexMutex.RLock()
+		index := searchIdx
+		searchIndexMutex.RUnlock()
+		if index == nil {
+			http.Error(w, "Location searches need the city database", http.StatusServiceUnavailable)
+			return
+		}
+		matches = index.search(q)
+	}
+	if q.hasASN {
+		asnIndexMutex.RLock()
+		index := asnIdx
+		asnIndexMutex.RUnlock()
+		if index == nil {
+			http.Error(w, "ASN searches need the ASN database", http.StatusServiceUnavailable)
+			return
+		}
+		prefixes := index.prefixes[q.asn]
+		if locationFilter {
+			matches = intersectMatches(matches, prefixes)
+		} else {
+			matches = make([]searchMatch, len(prefixes))
+			for i, prefix := range prefixes {
+				matches[i] = searchMatch{prefix: prefix}
+			}
+		}
+	}
+
+	if format == "cidr" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	asn := ""
+	if q.hasASN {
+		asn = fmt.Sprintf("AS%d", q.asn)
+	}
+	for i, match := range matches {
+		var err error
+		if format == "cidr" {
+			_, err = fmt.Fprintln(w, match.prefix)
+		} else {
+			result := searchResult{Network: match.prefix.String(), ASN: asn}
+			if match.location != nil {
+				result.CountryCode = match.location.CountryCode
+				result.RegionCode = match.location.RegionCode
+				result.Region = match.location.Region
+				result.City = match.location.City
+			}
+			err = encoder.Encode(result)
+		}
+		if err != nil {
+			reqLogger.Debug("Search response aborted", "error", err)
+			return
+		}
+		if flusher != nil && (i+1)%rangeFlushInterval == 0 {
+			flusher.Flush()
+		}
+	}
+	reqLogger.Debug("Successfully processed search", "networks", len(matches))
+}
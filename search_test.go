@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+func cityRecord(countryCode, regionCode, region, city string) searchRecord {
+	var record searchRecord
+	record.Country.IsoCode = countryCode
+	record.City.Names = map[string]string{"en": city}
+	if regionCode != "" {
+		record.Subdivisions = append(record.Subdivisions, struct {
+			IsoCode string            `maxminddb:"iso_code"`
+			Names   map[string]string `maxminddb:"names"`
+		}{regionCode, map[string]string{"en": region}})
+	}
+	return record
+}
+
+func setupSearchDatabases(t *testing.T) {
+	t.Helper()
+	originalDatabases := databases
+	originalConfig := config
+	t.Cleanup(func() {
+		databases = originalDatabases
+		config = originalConfig
+		buildASNIndex()
+		buildSearchIndex()
+	})
+
+	config = defaultConfig
+	databases = map[string]*dbConfig{
+		"city": {reader: &walkableReader{networks: []fakeNetwork{
+			{"2.160.0.0/12", cityRecord("DE", "BY", "Bavaria", "Munich")},
+			{"5.1.0.0/16", cityRecord("DE", "BE", "Land Berlin", "Berlin")},
+			{"81.2.68.0/23", cityRecord("GB", "ENG", "England", "London")},
+			{"2a02:8100::/27", cityRecord("DE", "BY", "Bavaria", "Nuremberg")},
+		}}},
+		"asn": {reader: &walkableReader{networks: []fakeNetwork{
+			{"2.160.0.0/16", geoip2.ASN{AutonomousSystemNumber: 3320, AutonomousSystemOrganization: "Deutsche Telekom AG"}},
+			{"5.0.0.0/8", geoip2.ASN{AutonomousSystemNumber: 3320, AutonomousSystemOrganization: "Deutsche Telekom AG"}},
+			{"81.2.64.0/20", geoip2.ASN{AutonomousSystemNumber: 20712, AutonomousSystemOrganization: "Andrews & Arnold Ltd"}},
+		}}},
+	}
+	buildASNIndex()
+	buildSearchIndex()
+}
+
+func searchLines(t *testing.T, query string) (int, []string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handleRequest(w, httptest.NewRequest("GET", "/search?"+query, nil))
+	body := strings.TrimSpace(w.Body.String())
+	if w.Code != http.StatusOK || body == "" {
+		return w.Code, nil
+	}
+	return w.Code, strings.Split(body, "\n")
+}
+
+func TestHandleSearch(t *testing.T) {
+	setupSearchDatabases(t)
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"country=DE&format=cidr", []string{"2.160.0.0/12", "5.1.0.0/16", "2a02:8100::/27"}},
+		{"country=deu&region=by&format=cidr", []string{"2.160.0.0/12", "2a02:8100::/27"}},
+		{"region=Bavaria&city=munich&format=cidr", []string{"2.160.0.0/12"}},
+		{"country=FR&format=cidr", nil},
+		// The AS prefix is more specific in one network, the city network in the other
+		{"country=DE&asn=AS3320&format=cidr", []string{"2.160.0.0/16", "5.1.0.0/16"}},
+		{"asn=20712&format=cidr", []string{"81.2.64.0/20"}},
+	}
+	for _, test := range tests {
+		status, lines := searchLines(t, test.query)
+		if status != http.StatusOK {
+			t.Errorf("Expected 200 for %s, got %d", test.query, status)
+		}
+		if strings.Join(lines, " ") != strings.Join(test.expected, " ") {
+			t.Errorf("Search %s: expected %v, got %v", test.query, test.expected, lines)
+		}
+	}
+
+	_, lines := searchLines(t, "city=Nuremberg&asn=3320")
+	if len(lines) != 0 {
+		t.Errorf("Expected no networks outside the AS, got %v", lines)
+	}
+
+	_, lines = searchLines(t, "country=DE&region=BE&asn=3320")
+	var result searchResult
+	if len(lines) != 1 || json.Unmarshal([]byte(lines[0]), &result) != nil {
+		t.Fatalf("Expected one JSON line, got %v", lines)
+	}
+	expected := searchResult{Network: "5.1.0.0/16", CountryCode: "DE", RegionCode: "BE", Region: "Land Berlin", City: "Berlin", ASN: "AS3320"}
+	if result != expected {
+		t.Errorf("Expected %+v, got %+v", expected, result)
+	}
+
+	for _, query := range []string{"", "format=cidr", "country=XX", "asn=ASX", "country=DE&format=xml"} {
+		if status, _ := searchLines(t, query); status != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %q, got %d", query, status)
+		}
+	}
+
+	databases = map[string]*dbConfig{"city": {reader: &MockReader{}}}
+	buildSearchIndex()
+	if status, _ := searchLines(t, "country=DE"); status != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without a walkable city database, got %d", status)
+	}
+}
+
+func TestHandleStatus(t *testing.T) {
+	setupSearchDatabases(t)
+
+	w := httptest.NewRecorder()
+	handleRequest(w, httptest.NewRequest("GET", "/status", nil))
+	var status struct {
+		Databases map[string]databaseStatus `json:"databases"`
+		Indexes   map[string]indexStatus    `json:"indexes"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Failed to decode status: %v", err)
+	}
+	if !status.Databases["city"].Loaded || !status.Databases["asn"].Loaded {
+		t.Errorf("Expected loaded databases, got %+v", status.Databases)
+	}
+	search := status.Indexes["search"]
+	if !search.Ready || search.Entries != 4 || search.MemoryBytes <= 0 {
+		t.Errorf("Expected search index size, got %+v", search)
+	}
+	asn := status.Indexes["asn"]
+	if !asn.Ready || asn.Entries != 2 || asn.MemoryBytes <= 0 {
+		t.Errorf("Expected ASN index size, got %+v", asn)
+	}
+}
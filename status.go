@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+// databaseStatus is a database entry of the GET /status response
+type databaseStatus struct {
+	Loaded  bool       `json:"loaded"`
+	Updated *time.Time `json:"updated,omitempty"`
+}
+
+// indexStatus is an index entry of the GET /status response
+type indexStatus struct {
+	Database    string `json:"database"`
+	Ready       bool   `json:"ready"`
+	Entries     int    `json:"entries"`      // ASNs or networks indexed
+	MemoryBytes int64  `json:"memory_bytes"` // Estimated size of the index
+}
+
+// handleStatus serves GET /status with the loaded databases and the size of
+// the indexes built from them
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := struct {
+		Databases map[string]databaseStatus `json:"databases"`
+		Indexes   map[string]indexStatus    `json:"indexes"`
+	}{
+		Databases: map[string]databaseStatus{},
+		Indexes:   map[string]indexStatus{},
+	}
+
+	names := make([]string, 0, len(databases))
+	for name := range databases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		db := databases[name]
+		db.mutex.RLock()
+		entry := databaseStatus{Loaded: db.reader != nil}
+		if entry.Loaded && !db.lastUpdate.IsZero() {
+			updated := db.lastUpdate
+			entry.Updated = &updated
+		}
+		db.mutex.RUnlock()
+		status.Databases[name] = entry
+	}
+
+	asnIndexMutex.RLock()
+	asn := indexStatus{Database: "asn"}
+	if asnIdx != nil {
+		asn.Ready, asn.Entries, asn.MemoryBytes = true, len(asnIdx.orgs), asnIdx.memory()
+	}
+	asnIndexMutex.RUnlock()
+	status.Indexes["asn"] = asn
+
+	searchIndexMutex.RLock()
+	search := indexStatus{Database: "city"}
+	if searchIdx != nil {
+		search.Ready, search.Entries, search.MemoryBytes = true, len(searchIdx.networks), searchIdx.memory()
+	}
+	searchIndexMutex.RUnlock()
+	status.Indexes["search"] = search
+
+	writeJSON(w, r, status)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	// Embed the IANA time zone database so results don't depend on the
+	// host's zoneinfo package
+	_ "time/tzdata"
+)
+
+// Time source, replaced in tests
+var timeNow = time.Now
+
+// Loaded time zones by name. Failed loads are cached too, an unknown name
+// stays unknown until the process restarts.
+var locationCache sync.Map
+
+type cachedLocation struct {
+	loc *time.Location
+	err error
+}
+
+// loadLocation is time.LoadLocation with a cache
+func loadLocation(name string) (*time.Location, error) {
+	if cached, ok := locationCache.Load(name); ok {
+		entry := cached.(cachedLocation)
+		return entry.loc, entry.err
+	}
+
+	loc, err := time.LoadLocation(name)
+	locationCache.Store(name, cachedLocation{loc: loc, err: err})
+	return loc, err
+}
+
+// formatUTCOffset formats an offset in seconds east of UTC as ±hhmm
+func formatUTCOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, offset/3600, offset%3600/60)
+}
+
+// addTimeInfo fills in the UTC offset, DST flag, zone abbreviation and
+// current local time for info.Timezone
+func addTimeInfo(info *IPInfo) {
+	if info.Timezone == "" {
+		return
+	}
+
+	loc, err := loadLocation(info.Timezone)
+	if err != nil {
+		logger.Debug("Unknown time zone", "timezone", info.Timezone, "error", err)
+		return
+	}
+
+	local := timeNow().In(loc)
+	abbreviation, offset := local.Zone()
+	info.UTCOffset = formatUTCOffset(offset)
+	info.IsDST = local.IsDST()
+	info.TimezoneAbbreviation = abbreviation
+	info.LocalTime = local.Format(time.RFC3339)
+}
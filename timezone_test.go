@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatUTCOffset(t *testing.T) {
+	tests := map[int]string{
+		0:                      "+0000",
+		5*3600 + 30*60:         "+0530",
+		5*3600 + 45*60:         "+0545",
+		-(3*3600 + 30*60):      "-0330",
+		-7 * 3600:              "-0700",
+		12*3600 + 45*60:        "+1245",
+		-(9*3600 + 30*60 + 10): "-0930",
+	}
+	for offset, expected := range tests {
+		if got := formatUTCOffset(offset); got != expected {
+			t.Errorf("formatUTCOffset(%d) = %s, expected %s", offset, got, expected)
+		}
+	}
+}
+
+func TestAddTimeInfo(t *testing.T) {
+	originalNow := timeNow
+	defer func() { timeNow = originalNow }()
+
+	// Mid-July: DST in St. John's, none in India and Nepal
+	timeNow = func() time.Time { return time.Date(2024, time.July, 15, 12, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		timezone     string
+		offset       string
+		dst          bool
+		abbreviation string
+		localTime    string
+	}{
+		{"Asia/Kolkata", "+0530", false, "IST", "2024-07-15T17:30:00+05:30"},
+		{"Asia/Kathmandu", "+0545", false, "+0545", "2024-07-15T17:45:00+05:45"},
+		{"America/St_Johns", "-0230", true, "NDT", "2024-07-15T09:30:00-02:30"},
+		{"America/New_York", "-0400", true, "EDT", "2024-07-15T08:00:00-04:00"},
+	}
+	for _, tt := range tests {
+		info := &IPInfo{Timezone: tt.timezone}
+		addTimeInfo(info)
+		if info.UTCOffset != tt.offset || info.IsDST != tt.dst || info.TimezoneAbbreviation != tt.abbreviation || info.LocalTime != tt.localTime {
+			t.Errorf("%s: got offset %s, dst %v, abbreviation %s, local time %s", tt.timezone,
+				info.UTCOffset, info.IsDST, info.TimezoneAbbreviation, info.LocalTime)
+		}
+	}
+
+	// In January Newfoundland is on standard time
+	timeNow = func() time.Time { return time.Date(2024, time.January, 15, 12, 0, 0, 0, time.UTC) }
+	info := &IPInfo{Timezone: "America/St_Johns"}
+	addTimeInfo(info)
+	if info.UTCOffset != "-0330" || info.IsDST {
+		t.Errorf("Expected -0330 without DST, got %s (dst %v)", info.UTCOffset, info.IsDST)
+	}
+
+	// Unknown zones leave the fields empty
+	info = &IPInfo{Timezone: "Mars/Olympus_Mons"}
+	addTimeInfo(info)
+	if info.UTCOffset != "" || info.LocalTime != "" {
+		t.Errorf("Expected no time info for unknown zone, got %+v", info)
+	}
+}
+
+func TestLoadLocationCache(t *testing.T) {
+	first, err := loadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("Failed to load location: %v", err)
+	}
+	second, _ := loadLocation("Europe/Berlin")
+	if first != second {
+		t.Error("Expected the cached *time.Location to be reused")
+	}
+
+	if _, err := loadLocation("Invalid/Zone"); err == nil {
+		t.Error("Expected error for invalid zone")
+	}
+	if _, err := loadLocation("Invalid/Zone"); err == nil {
+		t.Error("Expected cached error for invalid zone")
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// lookupTraits fills in the fields from the optional GeoIP2 Anonymous IP,
+// ISP, Connection Type and Domain databases. Fields of databases that aren't
+// loaded are left out of the response.
+func lookupTraits(info *IPInfo, ip net.IP) error {
+	var anonymous *geoip2.AnonymousIP
+	found, err := readDatabase("anonymous_ip", func(reader Reader) (err error) {
+		anonymous, err = reader.AnonymousIP(ip)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("anonymous IP lookup error: %v", err)
+	}
+	if found {
+		info.IsAnonymous = anonymous.IsAnonymous
+		info.IsVPN = anonymous.IsAnonymousVPN
+		info.IsHostingProvider = anonymous.IsHostingProvider
+		info.IsTorExitNode = anonymous.IsTorExitNode
+	} else {
+		info.omitDatabase("anonymous_ip")
+	}
+
+	var isp *geoip2.ISP
+	found, err = readDatabase("isp", func(reader Reader) (err error) {
+		isp, err = reader.ISP(ip)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("ISP lookup error: %v", err)
+	}
+	if found {
+		info.ISP = isp.ISP
+	} else {
+		info.omitDatabase("isp")
+	}
+
+	var connectionType *geoip2.ConnectionType
+	found, err = readDatabase("connection_type", func(reader Reader) (err error) {
+		connectionType, err = reader.ConnectionType(ip)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("connection type lookup error: %v", err)
+	}
+	if found {
+		info.ConnectionType = connectionType.ConnectionType
+	} else {
+		info.omitDatabase("connection_type")
+	}
+
+	var domain *geoip2.Domain
+	found, err = readDatabase("domain", func(reader Reader) (err error) {
+		domain, err = reader.Domain(ip)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("domain lookup error: %v", err)
+	}
+	if found {
+		info.Domain = domain.Domain
+	} else {
+		info.omitDatabase("domain")
+	}
+
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLookupTraits(t *testing.T) {
+	originalDatabases := databases
+	defer func() { databases = originalDatabases }()
+
+	databases = map[string]*dbConfig{
+		"asn":             {reader: &MockReader{}},
+		"anonymous_ip":    {reader: &MockReader{}},
+		"isp":             {reader: &MockReader{}},
+		"connection_type": {reader: &MockReader{}},
+		"domain":          {reader: &MockReader{}},
+	}
+	info, err := getIPInfo(net.ParseIP("81.2.69.142"))
+	if err != nil {
+		t.Fatalf("getIPInfo failed: %v", err)
+	}
+	if !info.IsAnonymous || !info.IsVPN || info.IsHostingProvider || info.IsTorExitNode {
+		t.Errorf("Unexpected anonymizer flags: %+v", info)
+	}
+	if info.ISP != "Test Broadband" {
+		t.Errorf("Expected ISP 'Test Broadband', got '%s'", info.ISP)
+	}
+	if info.ConnectionType != "Cable/DSL" {
+		t.Errorf("Expected connection type 'Cable/DSL', got '%s'", info.ConnectionType)
+	}
+	if info.Domain != "example.net" {
+		t.Errorf("Expected domain 'example.net', got '%s'", info.Domain)
+	}
+
+	// Without the optional databases their fields are left out
+	databases = map[string]*dbConfig{
+		"asn":    {reader: &MockReader{}},
+		"domain": {reader: &MockReader{}},
+	}
+	info, err = getIPInfo(net.ParseIP("81.2.69.142"))
+	if err != nil {
+		t.Fatalf("getIPInfo failed: %v", err)
+	}
+	data, _ := json.Marshal(info)
+	var fields map[string]interface{}
+	json.Unmarshal(data, &fields)
+	for _, key := range []string{"is_anonymous", "is_vpn", "is_hosting_provider", "is_tor_exit_node", "isp", "connection_type"} {
+		if _, ok := fields[key]; ok {
+			t.Errorf("Expected %s to be omitted without its database", key)
+		}
+	}
+	if fields["domain"] != "example.net" {
+		t.Errorf("Expected domain to be present, got %v", fields["domain"])
+	}
+
+	// Lookup errors are reported
+	databases = map[string]*dbConfig{
+		"isp": {reader: &ErrorMockReader{}},
+	}
+	if _, err := getIPInfo(net.ParseIP("81.2.69.142")); err == nil || !strings.Contains(err.Error(), "ISP lookup error") {
+		t.Errorf("Expected ISP lookup error, got %v", err)
+	}
+}
+
+func TestInitDatabasesOptional(t *testing.T) {
+	originalDatabases := databases
+	originalConfig := config
+	originalOpen := geoipOpen
+	defer func() {
+		databases = originalDatabases
+		config = originalConfig
+		geoipOpen = originalOpen
+	}()
+
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "GeoIP2-ISP.mmdb"), []byte("MOCK"), 0444); err != nil {
+		t.Fatalf("Failed to create database file: %v", err)
+	}
+	geoipOpen = func(filename string) (Reader, error) {
+		return &MockReader{}, nil
+	}
+
+	// Missing optional files without a download URL are skipped, present ones are opened
+	databases = map[string]*dbConfig{
+		"isp":    {localPath: filepath.Join(tempDir, "GeoIP2-ISP.mmdb"), optional: true},
+		"domain": {localPath: filepath.Join(tempDir, "GeoIP2-Domain.mmdb"), optional: true},
+	}
+	if err := initDatabases(); err != nil {
+		t.Fatalf("initDatabases failed: %v", err)
+	}
+	if databases["isp"].reader == nil {
+		t.Error("Expected present optional database to be opened")
+	}
+	if databases["domain"].reader != nil {
+		t.Error("Expected missing optional database to be skipped")
+	}
+
+	// The same goes for offline mode, even with a URL
+	config.Offline = true
+	databases = map[string]*dbConfig{
+		"domain": {url: "http://127.0.0.1:1/db", localPath: filepath.Join(tempDir, "GeoIP2-Domain.mmdb"), optional: true},
+	}
+	if err := initDatabases(); err != nil {
+		t.Errorf("Expected missing optional database not to fail offline mode, got %v", err)
+	}
+}
+
+func TestAnycastTrait(t *testing.T) {
+	originalDatabases := databases
+	defer func() { databases = originalDatabases }()
+
+	for _, anycast := range []bool{true, false} {
+		path := writeTestMMDB(t, "GeoIP2-Country", map[string]any{
+			"country": map[string]any{"iso_code": "AU"},
+			"traits":  map[string]any{"is_anycast": anycast},
+		})
+		reader, err := openMMDB(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+
+		databases = map[string]*dbConfig{"country": {reader: reader}}
+		info, err := getIPInfo(net.ParseIP("81.2.69.142"))
+		if err != nil {
+			t.Fatalf("getIPInfo failed: %v", err)
+		}
+		if info.CountryCode != "AU" || info.IsAnycastOrSatellite != anycast {
+			t.Errorf("Expected AU with anycast flag %v, got %q %v", anycast, info.CountryCode, info.IsAnycastOrSatellite)
+		}
+	}
+}
+
+// writeTestMMDB writes an IPv4 database mapping 0.0.0.0/1 to record
+func writeTestMMDB(t *testing.T, databaseType string, record map[string]any) string {
+	t.Helper()
+	const nodeCount = 1
+	var db []byte
+	// One node: the left record points to the first data section entry,
+	// the right one is empty
+	left := nodeCount + 16
+	db = append(db, byte(left>>16), byte(left>>8), byte(left), 0, 0, nodeCount)
+	db = append(db, make([]byte, 16)...)
+	db = append(db, encodeMMDB(record)...)
+	db = append(db, "\xab\xcd\xefMaxMind.com"...)
+	db = append(db, encodeMMDB(map[string]any{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(0),
+		"database_type":               databaseType,
+		"description":                 map[string]any{"en": "Test"},
+		"ip_version":                  uint16(4),
+		"languages":                   []any{"en"},
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(24),
+	})...)
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, db, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// encodeMMDB encodes a value in the MaxMind DB data format
+func encodeMMDB(value any) []byte {
+	control := func(kind, size int) []byte {
+		if kind <= 7 {
+			return []byte{byte(kind<<5 | size)}
+		}
+		return []byte{byte(size), byte(kind - 7)}
+	}
+	switch v := value.(type) {
+	case string:
+		return append(control(2, len(v)), v...)
+	case bool:
+		if v {
+			return control(14, 1)
+		}
+		return control(14, 0)
+	case uint16:
+		return append(control(5, 2), byte(v>>8), byte(v))
+	case uint32:
+		return append(control(6, 4), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	case uint64:
+		data := control(9, 8)
+		for shift := 56; shift >= 0; shift -= 8 {
+			data = append(data, byte(v>>shift))
+		}
+		return data
+	case []any:
+		data := control(11, len(v))
+		for _, item := range v {
+			data = append(data, encodeMMDB(item)...)
+		}
+		return data
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		data := control(7, len(v))
+		for _, key := range keys {
+			data = append(data, encodeMMDB(key)...)
+			data = append(data, encodeMMDB(v[key])...)
+		}
+		return data
+	}
+	panic(fmt.Sprintf("unsupported type %T", value))
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConfigError lists every problem found in a configuration
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid configuration:\n  - %s", strings.Join(e.Problems, "\n  - "))
+}
+
+// configValidator collects configuration problems so that all of them can
+// be reported at once
+type configValidator struct {
+	problems []string
+}
+
+// addf records a problem with the named field
+func (v *configValidator) addf(field string, format string, args ...interface{}) {
+	v.problems = append(v.problems, field+": "+fmt.Sprintf(format, args...))
+}
+
+// err returns a *ConfigError if any problems were found
+func (v *configValidator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &ConfigError{Problems: v.problems}
+}
+
+// port checks that value is a TCP/UDP port number
+func (v *configValidator) port(field, value string) {
+	port, err := strconv.Atoi(value)
+	if err != nil {
+		v.addf(field, "%q is not a number", value)
+		return
+	}
+	if port < 1 || port > 65535 {
+		v.addf(field, "%d is out of range 1-65535", port)
+	}
+}
+
+// fileExists checks that path names a readable regular file
+func (v *configValidator) fileExists(field, path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		v.addf(field, "%v", err)
+		return
+	}
+	if info.IsDir() {
+		v.addf(field, "%s is a directory", path)
+	}
+}
+
+// cidr checks that value is a CIDR prefix or a single IP address
+func (v *configValidator) cidr(field, value string) {
+	if _, _, err := net.ParseCIDR(value); err == nil {
+		return
+	}
+	if net.ParseIP(value) == nil {
+		v.addf(field, "%q is not a valid CIDR or IP address", value)
+	}
+}
+
+// url checks that value is an absolute http or https URL
+func (v *configValidator) url(field, value string) {
+	u, err := url.Parse(value)
+	if err != nil {
+		v.addf(field, "%v", err)
+		return
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.addf(field, "%q is not an http(s) URL", value)
+	}
+}
+
+// positive checks that a duration is greater than zero
+func (v *configValidator) positive(field string, value Duration) {
+	if value <= 0 {
+		v.addf(field, "must be greater than zero")
+	}
+}
+
+// checkSSL checks that the SSL settings are consistent
+func (v *configValidator) checkSSL(cfg Config) {
+	// If SSL is disabled but cert or key is specified, report an error
+	if !cfg.SSL && (cfg.Cert != "" || cfg.Key != "") {
+		v.addf("ssl", "SSL is disabled but certificate or key path is provided")
+	}
+
+	// If SSL is enabled and only one of cert or key is specified, report an error
+	if cfg.SSL && ((cfg.Cert != "" && cfg.Key == "") || (cfg.Cert == "" && cfg.Key != "")) {
+		v.addf("ssl", "both certificate and key must be provided when using SSL with custom certificates")
+	}
+}
+
+// validateConfig checks the whole configuration and reports every problem
+func validateConfig(cfg Config) error {
+	v := &configValidator{}
+
+	v.port("port", cfg.Port)
+
+	v.checkSSL(cfg)
+	if cfg.SSL {
+		if cfg.Cert != "" {
+			v.fileExists("cert", cfg.Cert)
+		}
+		if cfg.Key != "" {
+			v.fileExists("key", cfg.Key)
+		}
+	}
+
+	if cfg.DBDir == "" {
+		v.addf("db_dir", "must not be empty")
+	}
+	v.positive("update_interval", cfg.UpdateInterval)
+	v.positive("update_max_age", cfg.UpdateMaxAge)
+	v.checkDatabases(cfg.Databases)
+	v.checkCloudRanges(cfg.CloudRanges)
+	v.checkLists(cfg.Lists)
+	v.checkDNS(cfg.DNS)
+	v.checkRangeMaxPrefix(cfg.RangeMaxPrefix)
+	if cfg.RangeMaxNetworks < 0 {
+		v.addf("range_max_networks", "must not be negative")
+	}
+	v.checkTrustedProxies(cfg)
+	v.checkForwardAuth(cfg)
+	v.checkExtAuthz(cfg)
+	v.checkDNSServer(cfg)
+
+	if cfg.OverridesFile != "" {
+		if _, err := loadOverrides(cfg.OverridesFile); err != nil {
+			v.addf("overrides_file", "%v", err)
+		}
+	}
+
+	if cfg.PoliciesFile != "" {
+		if _, err := loadPolicies(cfg.PoliciesFile); err != nil {
+			v.addf("policies_file", "%v", err)
+		}
+	}
+
+	if _, err := parseLogLevel(cfg.Log.Level); err != nil {
+		v.addf("log.level", "%v", err)
+	}
+	if _, err := newLogHandler(io.Discard, cfg.Log.Format, 0); err != nil {
+		v.addf("log.format", "%v", err)
+	}
+	if cfg.Log.SampleRate < 0 || cfg.Log.SampleRate > 1 {
+		v.addf("log.sample_rate", "%v is out of range 0-1", cfg.Log.SampleRate)
+	}
+
+	return v.err()
+}
+
+// unknownConfigKeys returns the dotted names of every key in data that
+// doesn't correspond to a field of t
+func unknownConfigKeys(data []byte, t reflect.Type, prefix string) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return nil
+	}
+
+	var unknown []string
+	switch t.Kind() {
+	case reflect.Struct:
+		var object map[string]json.RawMessage
+		if json.Unmarshal(data, &object) != nil {
+			return nil
+		}
+		fields := map[string]reflect.Type{}
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+			if name != "" && name != "-" {
+				fields[name] = t.Field(i).Type
+			}
+		}
+		for key, raw := range object {
+			fieldType, ok := fields[key]
+			if !ok {
+				unknown = append(unknown, prefix+key)
+				continue
+			}
+			unknown = append(unknown, unknownConfigKeys(raw, fieldType, prefix+key+".")...)
+		}
+	case reflect.Map:
+		var object map[string]json.RawMessage
+		if json.Unmarshal(data, &object) != nil {
+			return nil
+		}
+		for key, raw := range object {
+			unknown = append(unknown, unknownConfigKeys(raw, t.Elem(), prefix+key+".")...)
+		}
+	case reflect.Slice:
+		var items []json.RawMessage
+		if json.Unmarshal(data, &items) != nil {
+			return nil
+		}
+		for i, raw := range items {
+			unknown = append(unknown, unknownConfigKeys(raw, t.Elem(), fmt.Sprintf("%s%d.", prefix, i))...)
+		}
+	}
+
+	sort.Strings(unknown)
+	return unknown
+}
+
+// decodeConfig decodes data into target, a struct field by field, and
+// records a problem for every value of the wrong type. Unknown keys are
+// skipped; unknownConfigKeys reports them.
+func decodeConfig(data []byte, target reflect.Value, prefix string, v *configValidator) {
+	t := target.Type()
+	if t.Kind() == reflect.Struct && !reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		var object map[string]json.RawMessage
+		if json.Unmarshal(data, &object) == nil {
+			fields := map[string]int{}
+			for i := 0; i < t.NumField(); i++ {
+				name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+				if name != "" && name != "-" {
+					fields[name] = i
+				}
+			}
+			keys := make([]string, 0, len(object))
+			for key := range object {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				if i, ok := fields[key]; ok {
+					decodeConfig(object[key], target.Field(i), prefix+key+".", v)
+				}
+			}
+			return
+		}
+	}
+
+	field := strings.TrimSuffix(prefix, ".")
+	err := json.Unmarshal(data, target.Addr().Interface())
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &typeErr):
+		value := string(data)
+		if typeErr.Field != "" {
+			field += "." + typeErr.Field
+			value = "a number"
+		}
+		if typeErr.Type.Kind() == reflect.String && typeErr.Value == "number" {
+			// Ports are strings, e.g. "8080"
+			v.addf(field, "expected a string, got %s; quote it", value)
+		} else {
+			v.addf(field, "expected %s, got %s", typeErr.Type, typeErr.Value)
+		}
+	case err != nil:
+		v.addf(field, "%v", err)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	// The defaults must always be valid
+	if err := validateConfig(defaultConfig); err != nil {
+		t.Fatalf("Default configuration is invalid: %v", err)
+	}
+
+	cfg := defaultConfig
+	cfg.Port = "http"
+	cfg.SSL = true
+	cfg.Cert = "/nonexistent/server.crt"
+	cfg.Key = "/nonexistent/server.key"
+	cfg.UpdateInterval = 0
+	cfg.Log.Level = "loud"
+	cfg.Log.SampleRate = 2
+
+	err := validateConfig(cfg)
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) {
+		t.Fatalf("Expected *ConfigError, got %v", err)
+	}
+
+	// Every problem is reported, not just the first one
+	expectedFields := []string{"port:", "cert:", "key:", "update_interval:", "log.level:", "log.sample_rate:"}
+	if len(configErr.Problems) != len(expectedFields) {
+		t.Errorf("Expected %d problems, got %d: %v", len(expectedFields), len(configErr.Problems), configErr.Problems)
+	}
+	for _, field := range expectedFields {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("Expected a problem for %s in: %v", field, err)
+		}
+	}
+
+	cfg = defaultConfig
+	cfg.Port = "70000"
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("Expected out of range port error, got %v", err)
+	}
+}
+
+func TestValidateConfigExistingCertificate(t *testing.T) {
+	tempDir := t.TempDir()
+	certFile := filepath.Join(tempDir, "server.crt")
+	keyFile := filepath.Join(tempDir, "server.key")
+	for _, path := range []string{certFile, keyFile} {
+		if err := os.WriteFile(path, []byte("test"), 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	cfg := defaultConfig
+	cfg.SSL = true
+	cfg.Cert = certFile
+	cfg.Key = keyFile
+	if err := validateConfig(cfg); err != nil {
+		t.Errorf("Expected valid configuration, got %v", err)
+	}
+
+	// A directory is not a certificate
+	cfg.Key = tempDir
+	if err := validateConfig(cfg); err == nil {
+		t.Error("Expected error when key is a directory, got nil")
+	}
+}
+
+func TestConfigValidatorHelpers(t *testing.T) {
+	v := &configValidator{}
+	v.cidr("ok_cidr", "10.0.0.0/8")
+	v.cidr("ok_ip", "2001:db8::1")
+	v.url("ok_url", "https://example.com/GeoLite2-City.mmdb")
+	if err := v.err(); err != nil {
+		t.Errorf("Expected valid values to pass, got %v", err)
+	}
+
+	v.cidr("bad_cidr", "10.0.0.0/33")
+	v.url("bad_url", "ftp://example.com/db")
+	v.url("relative_url", "/db.mmdb")
+	var configErr *ConfigError
+	if !errors.As(v.err(), &configErr) || len(configErr.Problems) != 3 {
+		t.Errorf("Expected 3 problems, got %v", v.err())
+	}
+}
+
+func TestUnknownConfigKeys(t *testing.T) {
+	data := []byte(`{
+		"host": "",
+		"prot": "5324",
+		"log": {"level": "info", "colour": true},
+		"update_interval": "1h"
+	}`)
+
+	unknown := unknownConfigKeys(data, reflect.TypeOf(Config{}), "")
+	expected := []string{"log.colour", "prot"}
+	if !reflect.DeepEqual(unknown, expected) {
+		t.Errorf("Expected unknown keys %v, got %v", expected, unknown)
+	}
+}
+
+func TestLoadConfigStrict(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	// Unknown keys are rejected
+	if err := os.WriteFile(path, []byte(`{"port": "8080", "sll": true}`), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if err := loadConfig(path); err == nil || !strings.Contains(err.Error(), "sll: unknown field") {
+		t.Errorf("Expected unknown field error, got %v", err)
+	}
+
+	// A numeric port is a type error
+	if err := os.WriteFile(path, []byte(`{"port": 8080}`), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if err := loadConfig(path); err == nil || !strings.Contains(err.Error(), "port: expected a string, got 8080; quote it") {
+		t.Errorf("Expected error for numeric port, got %v", err)
+	}
+
+	// A partial file leaves every other value at its default
+	config.Host = "stale.example.com"
+	if err := os.WriteFile(path, []byte(`{"port": "8080"}`), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if err := loadConfig(path); err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if config.Port != "8080" {
+		t.Errorf("Expected port '8080', got '%s'", config.Port)
+	}
+	if config.Host != defaultConfig.Host || config.DBDir != defaultConfig.DBDir {
+		t.Errorf("Expected unspecified values to be defaults, got host '%s' and db_dir '%s'", config.Host, config.DBDir)
+	}
+}
+
+func TestLoadConfigReportsAllProblems(t *testing.T) {
+	originalConfig := config
+	originalPath := configPath
+	defer func() {
+		config = originalConfig
+		configPath = originalPath
+	}()
+
+	configPath = filepath.Join(t.TempDir(), "config.json")
+	content := `{
+		"bogus": 1,
+		"port": "abc",
+		"ssl": "yes",
+		"log": {"level": 3},
+		"databases": {"city": {"url": 5}}
+	}`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	err := loadEffectiveConfig(false, true)
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) {
+		t.Fatalf("Expected a ConfigError, got %v", err)
+	}
+	for _, expected := range []string{
+		"bogus: unknown field",
+		"ssl: expected bool, got string",
+		"log.level: expected a string, got 3; quote it",
+		"databases.city.url: expected a string, got a number; quote it",
+		`port: "abc" is not a number`,
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected problem %q, got %v", expected, err)
+		}
+	}
+
+	// Syntax errors can't be narrowed down to a field
+	if err := os.WriteFile(configPath, []byte(`{"port": "8080",}`), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if err := loadEffectiveConfig(false, true); err == nil || !strings.Contains(err.Error(), "failed to parse") {
+		t.Errorf("Expected parse error, got %v", err)
+	}
+}
+
+func TestValidateDatabases(t *testing.T) {
+	cfg := defaultConfig
+	cfg.Databases = DatabaseSources{
+		"city":    {Enabled: true, URL: "git.io/GeoLite2-City.mmdb"},
+		"country": {Enabled: true, File: "../country.mmdb"},
+		"weather": {Enabled: true},
+	}
+
+	err := validateConfig(cfg)
+	for _, expected := range []string{"databases.city.url", "databases.country.file", "databases.weather: unknown database"} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected problem %q, got %v", expected, err)
+		}
+	}
+
+	// A single enabled database without a URL is fine
+	cfg.Databases = DatabaseSources{
+		"asn":     {Enabled: false},
+		"country": {Enabled: true, File: "GeoIP2-Country.mmdb"},
+	}
+	if err := validateConfig(cfg); err != nil {
+		t.Errorf("Expected country-only configuration to be valid, got %v", err)
+	}
+
+	cfg.Databases = DatabaseSources{"country": {Enabled: false}}
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "at least one database") {
+		t.Errorf("Expected error when no database is enabled, got %v", err)
+	}
+}